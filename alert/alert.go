@@ -0,0 +1,415 @@
+package alert
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+// Состояния оповещения
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Виды оповещений
+const (
+	AlertServiceDown    = "ServiceDown"
+	AlertServiceWarning = "ServiceWarning"
+	AlertCanaryDown     = "CanaryDown"
+)
+
+// Уровни важности оповещения
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// Alert - оповещение о проблеме с сервисом
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Name        string            `json:"alertname"`
+	Service     string            `json:"service"`
+	Address     string            `json:"address"`
+	Severity    string            `json:"severity"`
+	Status      string            `json:"status"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Summary     string            `json:"summary"`
+	StartsAt    time.Time         `json:"starts_at"`
+	EndsAt      time.Time         `json:"ends_at,omitempty"`
+	AckURL      string            `json:"ack_url,omitempty"`
+}
+
+// Notification - сообщение, отправляемое получателю по группе оповещений
+type Notification struct {
+	Receiver    string            `json:"receiver"`
+	Status      string            `json:"status"`
+	GroupLabels map[string]string `json:"group_labels"`
+	Alerts      []Alert           `json:"alerts"`
+}
+
+// Тип - группа оповещений, отправляемых одним сообщением
+type group struct {
+	key        string
+	route      *matchedRoute
+	labels     map[string]string
+	alerts     map[string]*Alert
+	notified   map[string]bool // оповещения, о срабатывании которых получатель группы уведомлён
+	changed    bool
+	nextFlush  time.Time
+	lastNotify time.Time
+}
+
+// Тип - отправка сообщения получателю
+type delivery struct {
+	receiver     helper.Receiver
+	notification *Notification
+}
+
+// dispatcher - синглтон, маршрутизирует и группирует оповещения
+type dispatcher struct {
+	mutex           sync.Mutex
+	config          helper.AlertingConfig
+	externalURL     string
+	stateDir        string
+	groups          map[string]*group
+	active          map[string]*ActiveAlert
+	maintenance     []helper.MaintenanceWindow
+	silences        map[string]*Silence
+	dependencies    map[string][]string // зависимости сервисов по именам
+	dirty           bool
+	ShutdownChannel chan string
+}
+
+var d dispatcher
+
+//----------------------------------------------------------------------------------------------------------------------
+// Метки оповещения, по которым выполняется маршрутизация и группировка
+//----------------------------------------------------------------------------------------------------------------------
+func (a *Alert) Labels() map[string]string {
+	labels := make(map[string]string, len(a.Tags)+3)
+	for name, value := range a.Tags {
+		labels[name] = value
+	}
+	labels["alertname"] = a.Name
+	labels["service"] = a.Service
+	labels["severity"] = a.Severity
+	return labels
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск диспетчера оповещений
+//----------------------------------------------------------------------------------------------------------------------
+func Startup(cfg *helper.Config) {
+	log.Info("alert.Startup, Started")
+	d = dispatcher{
+		config:          cfg.Alerting,
+		externalURL:     cfg.ExternalURL,
+		stateDir:        cfg.StateDir,
+		groups:          make(map[string]*group),
+		active:          make(map[string]*ActiveAlert),
+		maintenance:     cfg.Maintenance,
+		dependencies:    dependencies(cfg),
+		ShutdownChannel: make(chan string),
+	}
+	d.loadState()
+	d.loadSilences()
+	go d.loop()
+	log.Info("alert.Startup, Completed")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение новой конфигурации. Накопленные группы оповещений и состояние эскалации сохраняются.
+//----------------------------------------------------------------------------------------------------------------------
+func Reload(cfg *helper.Config) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.config = cfg.Alerting
+	d.externalURL = cfg.ExternalURL
+	d.maintenance = cfg.Maintenance
+	d.dependencies = dependencies(cfg)
+	if d.stateDir != cfg.StateDir {
+		d.stateDir = cfg.StateDir
+		d.dirty = true
+		d.saveSilences()
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка диспетчера оповещений
+//----------------------------------------------------------------------------------------------------------------------
+func Shutdown() {
+	log.Info("alert.Shutdown, Started")
+	d.ShutdownChannel <- "Down"
+	<-d.ShutdownChannel
+	close(d.ShutdownChannel)
+	d.mutex.Lock()
+	d.saveState()
+	d.mutex.Unlock()
+	log.Info("alert.Shutdown, Completed")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Приём оповещения и распределение его по группам согласно дереву маршрутизации
+//----------------------------------------------------------------------------------------------------------------------
+func Notify(a *Alert) {
+	if a.Fingerprint == "" {
+		a.Fingerprint = fingerprint(a)
+	}
+	now := time.Now()
+
+	d.mutex.Lock()
+	if a.Status == StatusFiring {
+		if silenced, reason := d.inMaintenance(a.Service, a.Tags, now); silenced {
+			d.mutex.Unlock()
+			log.Infof("alert.Notify, оповещение %s [%s] подавлено: %s", a.Name, a.Service, reason)
+			return
+		}
+	}
+	var deliveries []delivery
+	if a.Status == StatusResolved {
+		deliveries = d.resolve(a)
+	} else {
+		deliveries = d.fire(a, now)
+	}
+	d.mutex.Unlock()
+
+	for _, item := range deliveries {
+		send(item.receiver, item.notification)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Распределение сработавшего оповещения по группам согласно дереву маршрутизации. Группы запоминаются
+// в активном оповещении, отбой будет отправлен в них же.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) fire(a *Alert, now time.Time) []delivery {
+	routes := matchRoutes(d.config.Route, a, now)
+	deliveries := d.track(a, routes)
+	if len(routes) == 0 {
+		log.Debugf("alert.Notify, маршруты не настроены, оповещение %s [%s] не отправлено", a.Name, a.Service)
+	}
+	active := d.active[a.Fingerprint]
+	for _, route := range routes {
+		labels := groupLabels(route.groupBy, a)
+		key := route.path + ":" + labelsKey(labels)
+		g, ok := d.groups[key]
+		if !ok {
+			g = &group{
+				key:       key,
+				route:     route,
+				labels:    labels,
+				alerts:    make(map[string]*Alert),
+				notified:  make(map[string]bool),
+				nextFlush: now.Add(route.groupWait),
+			}
+			d.groups[key] = g
+		}
+		// Параметры маршрута могли измениться после перезагрузки конфигурации
+		g.route = route
+		d.addToGroup(g, a)
+		if active != nil && !active.inGroup(key) {
+			active.Groups = append(active.Groups, groupRef{Route: route.path, Key: key})
+			d.dirty = true
+		}
+	}
+	return deliveries
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отбой оповещения в группы, в которые оно попало при срабатывании. Маршруты повторно не вычисляются:
+// с момента срабатывания могли измениться время active_time или конфигурация.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) resolve(a *Alert) []delivery {
+	var keys []string
+	if active, ok := d.active[a.Fingerprint]; ok && len(active.Groups) > 0 {
+		for _, ref := range active.Groups {
+			keys = append(keys, ref.Key)
+		}
+	} else {
+		// Состояние сохранено прежней версией без списка групп: группы, содержащие оповещение
+		for key, g := range d.groups {
+			if _, found := g.alerts[a.Fingerprint]; found {
+				keys = append(keys, key)
+			}
+		}
+	}
+	// Отбой получает время начала проблемы из активного оповещения
+	deliveries := d.track(a, nil)
+	for _, key := range keys {
+		g, ok := d.groups[key]
+		if !ok {
+			// Нечего восстанавливать - о проблеме получатель не уведомлялся
+			continue
+		}
+		d.addToGroup(g, a)
+	}
+	return deliveries
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Добавление оповещения в группу: группа будет отправлена через group_wait для новой группы
+// или через group_interval после предыдущей отправки
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) addToGroup(g *group, a *Alert) {
+	if !g.changed && !g.lastNotify.IsZero() {
+		g.nextFlush = g.lastNotify.Add(g.route.groupInterval)
+	}
+	alertCopy := *a
+	g.alerts[a.Fingerprint] = &alertCopy
+	g.changed = true
+	log.Debugf("alert.Notify, оповещение %s [%s] %s добавлено в группу %s", a.Name, a.Service, a.Status, g.key)
+}
+
+func (active *ActiveAlert) inGroup(key string) bool {
+	for _, ref := range active.Groups {
+		if ref.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Рабочий цикл диспетчера: отправка групп и эскалация, для которых наступило время
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) loop() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.ShutdownChannel:
+			log.Info("alert.loop, выключение диспетчера оповещений")
+			d.ShutdownChannel <- "Down"
+			return
+		case now := <-t.C:
+			d.flush(now)
+		}
+	}
+}
+
+func (d *dispatcher) flush(now time.Time) {
+	var deliveries []delivery
+
+	d.mutex.Lock()
+	for key, g := range d.groups {
+		repeat := !g.changed && !g.lastNotify.IsZero() && now.Sub(g.lastNotify) >= g.route.repeatInterval
+		if repeat && d.allAcked(g) {
+			// Подтверждённые оповещения не повторяются
+			g.lastNotify = now
+			continue
+		}
+		if !repeat && (!g.changed || now.Before(g.nextFlush)) {
+			continue
+		}
+		receiver, ok := d.receiver(g.route.receiver)
+		if !ok {
+			log.Errorf("alert.flush, получатель %q не найден, группа %s", g.route.receiver, key)
+		} else if n := d.notification(g, now); len(n.Alerts) > 0 {
+			deliveries = append(deliveries, delivery{receiver, n})
+		}
+		g.changed = false
+		g.lastNotify = now
+		// Восстановленные оповещения отправляются один раз
+		for fp, a := range g.alerts {
+			if a.Status == StatusResolved {
+				delete(g.alerts, fp)
+			}
+		}
+		if len(g.alerts) == 0 {
+			delete(d.groups, key)
+		}
+	}
+	deliveries = append(deliveries, d.escalate(now)...)
+	d.expireSilences(now)
+	if d.dirty {
+		d.saveState()
+	}
+	d.mutex.Unlock()
+
+	for _, item := range deliveries {
+		send(item.receiver, item.notification)
+	}
+}
+
+func (d *dispatcher) receiver(name string) (helper.Receiver, bool) {
+	for _, receiver := range d.config.Receivers {
+		if receiver.Name == name {
+			return receiver, true
+		}
+	}
+	return helper.Receiver{}, false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Формирование сообщения по группе оповещений. Сработавшие оповещения сервисов в окне обслуживания не отправляются
+// и не повторяются: проблема могла возникнуть до начала окна. Отбой отправляется, только если получатель
+// был уведомлён о срабатывании: оповещение могло подавляться из-за недоступной зависимости.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) notification(g *group, now time.Time) *Notification {
+	n := &Notification{
+		Receiver:    g.route.receiver,
+		Status:      StatusResolved,
+		GroupLabels: g.labels,
+	}
+	for _, a := range g.alerts {
+		if a.Status == StatusFiring {
+			if cause := d.rootCause(a.Service); cause != "" {
+				log.Infof("alert.flush, оповещение %s [%s] подавлено: недоступна зависимость %s", a.Name, a.Service, cause)
+				continue
+			}
+			if silenced, reason := d.inMaintenance(a.Service, a.Tags, now); silenced {
+				log.Infof("alert.flush, оповещение %s [%s] подавлено: %s", a.Name, a.Service, reason)
+				continue
+			}
+			n.Status = StatusFiring
+			g.notified[a.Fingerprint] = true
+		} else if !g.notified[a.Fingerprint] {
+			log.Debugf("alert.flush, отбой %s [%s] не отправлен: получатель не уведомлялся о срабатывании", a.Name, a.Service)
+			continue
+		} else {
+			delete(g.notified, a.Fingerprint)
+		}
+		n.Alerts = append(n.Alerts, d.withAckURL(*a))
+	}
+	sort.Slice(n.Alerts, func(i, j int) bool { return n.Alerts[i].StartsAt.Before(n.Alerts[j].StartsAt) })
+	return n
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Метки, по которым оповещение относится к группе
+//----------------------------------------------------------------------------------------------------------------------
+func groupLabels(groupBy []string, a *Alert) map[string]string {
+	all := a.Labels()
+	labels := make(map[string]string)
+	for _, name := range groupBy {
+		if name == "..." {
+			return all
+		}
+		labels[name] = all[name]
+	}
+	return labels
+}
+
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + labels[name]
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func fingerprint(a *Alert) string {
+	sum := sha1.Sum([]byte(a.Name + "\x00" + a.Service))
+	return hex.EncodeToString(sum[:8])
+}
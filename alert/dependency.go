@@ -0,0 +1,52 @@
+package alert
+
+import (
+	"ws_monitoring/helper"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+// Граф зависимостей сервисов из конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func dependencies(cfg *helper.Config) map[string][]string {
+	graph := make(map[string][]string, len(cfg.Services))
+	for _, service := range cfg.Services {
+		if len(service.DependsOn) > 0 {
+			graph[service.Name] = service.DependsOn
+		}
+	}
+	return graph
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Поиск недоступной зависимости сервиса среди всех его предков.
+// Возвращает имя зависимости, по которой есть активное оповещение о недоступности.
+// Проверка выполняется при отправке группы, поэтому оповещение дочернего сервиса, поступившее
+// раньше оповещения о первопричине, также подавляется, если оба попали в интервал group_wait.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) rootCause(service string) string {
+	if len(d.dependencies[service]) == 0 {
+		return ""
+	}
+	down := make(map[string]bool)
+	for _, active := range d.active {
+		if active.Alert.Status == StatusFiring &&
+			(active.Alert.Name == AlertServiceDown || active.Alert.Name == AlertCanaryDown) {
+			down[active.Alert.Service] = true
+		}
+	}
+	visited := map[string]bool{service: true}
+	queue := append([]string(nil), d.dependencies[service]...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		if down[name] {
+			return name
+		}
+		queue = append(queue, d.dependencies[name]...)
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package alert
+
+import (
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+)
+
+// Отбой оповещения, подавленного из-за недоступной зависимости, не отправляется: получатель
+// не видел срабатывания. Отбой оповещения, о котором получатель уведомлён, отправляется.
+func TestResolveSuppressedByDependency(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		parent   bool   // зависимость db недоступна до срабатывания оповещения api
+		firing   int    // оповещений api в сообщении о срабатывании
+		resolved int    // оповещений api в сообщении об отбое
+		status   string // состояние сообщения об отбое
+	}{
+		{"срабатывание подавлено", true, 0, 0, StatusFiring},
+		{"получатель уведомлён", false, 1, 1, StatusResolved},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dp := &dispatcher{
+				config:       helper.AlertingConfig{Route: &helper.Route{Receiver: "team", GroupBy: []string{"alertname"}}},
+				groups:       make(map[string]*group),
+				active:       make(map[string]*ActiveAlert),
+				dependencies: map[string][]string{"api": {"db"}},
+			}
+			notify := func(service string, status string) {
+				a := &Alert{Name: AlertServiceDown, Service: service, Severity: SeverityCritical, Status: status, StartsAt: now}
+				a.Fingerprint = fingerprint(a)
+				if status == StatusFiring {
+					dp.fire(a, now)
+				} else {
+					dp.resolve(a)
+				}
+			}
+			count := func(n *Notification) int {
+				found := 0
+				for _, a := range n.Alerts {
+					if a.Service == "api" {
+						found++
+					}
+				}
+				return found
+			}
+			if test.parent {
+				notify("db", StatusFiring)
+			}
+			notify("api", StatusFiring)
+			g := dp.groups["0:{alertname=ServiceDown}"]
+			if n := dp.notification(g, now); count(n) != test.firing {
+				t.Errorf("оповещений api при срабатывании %d, ожидается %d", count(n), test.firing)
+			}
+
+			// Сервис api восстановился, пока db ещё недоступен
+			notify("api", StatusResolved)
+			n := dp.notification(g, now)
+			if count(n) != test.resolved || n.Status != test.status {
+				t.Errorf("оповещений api при отбое %d (%s), ожидается %d (%s)", count(n), n.Status, test.resolved, test.status)
+			}
+		})
+	}
+}
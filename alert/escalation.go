@@ -0,0 +1,265 @@
+package alert
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+// Имя файла состояния активных оповещений в каталоге состояния
+const stateFileName = "alerts.json"
+
+var (
+	ErrAlertNotFound = errors.New("Оповещение не найдено")
+	ErrBadSignature  = errors.New("Неверная подпись ссылки")
+)
+
+// ActiveAlert - активное оповещение с состоянием подтверждения и эскалации.
+// Сохраняется в каталоге состояния и переживает перезагрузку конфигурации и перезапуск программы.
+type ActiveAlert struct {
+	Alert    Alert      `json:"alert"`
+	Policy   string     `json:"policy,omitempty"`
+	Tier     int        `json:"tier"` // количество пройденных уровней эскалации
+	Notified []string   `json:"notified,omitempty"`
+	Acked    bool       `json:"acked"`
+	AckedBy  string     `json:"acked_by,omitempty"`
+	AckedAt  time.Time  `json:"acked_at,omitempty"`
+	Groups   []groupRef `json:"groups,omitempty"` // группы, в которые оповещение попало при срабатывании
+}
+
+// Тип - группа оповещений, в которую попало сработавшее оповещение: отбой отправляется в неё же,
+// даже если маршрутизация изменилась, например закончилось время active_time
+type groupRef struct {
+	Route string `json:"route"` // путь маршрута в дереве, например 0.1
+	Key   string `json:"key"`   // ключ группы: путь маршрута и метки группировки
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Учёт активного оповещения. Для повторно поступившего оповещения сохраняется исходное время начала,
+// чтобы после перезапуска эскалация продолжилась, а не началась заново.
+// При восстановлении возвращает отбой для получателей, до которых дошла эскалация.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) track(a *Alert, routes []*matchedRoute) []delivery {
+	active, ok := d.active[a.Fingerprint]
+	if a.Status == StatusResolved {
+		if !ok {
+			return nil
+		}
+		a.StartsAt = active.Alert.StartsAt
+		delete(d.active, a.Fingerprint)
+		d.dirty = true
+		var deliveries []delivery
+		for _, name := range active.Notified {
+			if receiver, found := d.receiver(name); found {
+				deliveries = append(deliveries, delivery{receiver, d.single(name, *a)})
+			}
+		}
+		return deliveries
+	}
+
+	if ok {
+		a.StartsAt = active.Alert.StartsAt
+		active.Alert = *a
+		return nil
+	}
+	active = &ActiveAlert{Alert: *a}
+	for _, route := range routes {
+		if route.escalation != "" {
+			active.Policy = route.escalation
+			break
+		}
+	}
+	d.active[a.Fingerprint] = active
+	d.dirty = true
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Эскалация неподтверждённых оповещений по уровням политики. Оповещения сервисов в окне обслуживания
+// или под действием тишины не эскалируются до его окончания.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) escalate(now time.Time) []delivery {
+	var deliveries []delivery
+	for _, active := range d.active {
+		if active.Acked || active.Policy == "" || d.rootCause(active.Alert.Service) != "" {
+			continue
+		}
+		// Проблема, возникшая до начала окна обслуживания, не эскалируется, пока окно действует
+		if silenced, _ := d.inMaintenance(active.Alert.Service, active.Alert.Tags, now); silenced {
+			continue
+		}
+		policy, ok := d.policy(active.Policy)
+		if !ok {
+			continue
+		}
+		for active.Tier < len(policy.Tiers) {
+			tier := policy.Tiers[active.Tier]
+			if now.Sub(active.Alert.StartsAt) < tier.Delay.Duration() {
+				break
+			}
+			log.Infof("alert.escalate, оповещение %s [%s], уровень %d: %s",
+				active.Alert.Name, active.Alert.Service, active.Tier+1, tier.Receiver)
+			if receiver, found := d.receiver(tier.Receiver); found {
+				deliveries = append(deliveries, delivery{receiver, d.single(tier.Receiver, active.Alert)})
+				active.Notified = append(active.Notified, tier.Receiver)
+			} else {
+				log.Errorf("alert.escalate, получатель %q не найден, политика %s", tier.Receiver, policy.Name)
+			}
+			active.Tier++
+			d.dirty = true
+		}
+	}
+	return deliveries
+}
+
+func (d *dispatcher) policy(name string) (helper.EscalationPolicy, bool) {
+	for _, policy := range d.config.EscalationPolicies {
+		if policy.Name == name {
+			return policy, true
+		}
+	}
+	return helper.EscalationPolicy{}, false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Сообщение по одному оповещению
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) single(receiver string, a Alert) *Notification {
+	return &Notification{
+		Receiver:    receiver,
+		Status:      a.Status,
+		GroupLabels: map[string]string{"alertname": a.Name, "service": a.Service},
+		Alerts:      []Alert{d.withAckURL(a)},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Все активные оповещения группы подтверждены
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) allAcked(g *group) bool {
+	for fp := range g.alerts {
+		if active, ok := d.active[fp]; !ok || !active.Acked {
+			return false
+		}
+	}
+	return true
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Список активных оповещений
+//----------------------------------------------------------------------------------------------------------------------
+func Active() []ActiveAlert {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	list := make([]ActiveAlert, 0, len(d.active))
+	for _, active := range d.active {
+		item := *active
+		item.Alert = d.withAckURL(item.Alert)
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Alert.StartsAt.Before(list[j].Alert.StartsAt) })
+	return list
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Подтверждение оповещения, эскалация по нему прекращается
+//----------------------------------------------------------------------------------------------------------------------
+func Acknowledge(fingerprint string, by string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	active, ok := d.active[fingerprint]
+	if !ok {
+		return ErrAlertNotFound
+	}
+	d.acknowledgeLocked(active, by)
+	return nil
+}
+
+// Подтверждение активного оповещения, вызывается под мьютексом диспетчера
+func (d *dispatcher) acknowledgeLocked(active *ActiveAlert, by string) {
+	if active.Acked {
+		return
+	}
+	active.Acked = true
+	active.AckedBy = by
+	active.AckedAt = time.Now()
+	d.dirty = true
+	log.Infof("alert.Acknowledge, оповещение %s [%s] подтверждено: %s", active.Alert.Name, active.Alert.Service, by)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Подтверждение оповещения по подписанной ссылке из сообщения.
+// Подпись включает время начала проблемы, поэтому ссылка из старого сообщения не подтвердит новую проблему.
+// Поиск, сравнение времени начала и подтверждение выполняются под одной блокировкой.
+//----------------------------------------------------------------------------------------------------------------------
+func AcknowledgeSigned(fingerprint string, startsAt string, signature string, by string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	secret := d.config.AckSecret.Value()
+	if secret == "" {
+		return ErrBadSignature
+	}
+	expected := sign(secret, fingerprint, startsAt)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrBadSignature
+	}
+	active, ok := d.active[fingerprint]
+	if !ok || strconv.FormatInt(active.Alert.StartsAt.Unix(), 10) != startsAt {
+		return ErrAlertNotFound
+	}
+	d.acknowledgeLocked(active, by)
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Добавление в оповещение подписанной ссылки для подтверждения
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) withAckURL(a Alert) Alert {
+	if a.Status != StatusFiring || d.externalURL == "" || d.config.AckSecret == "" {
+		return a
+	}
+	startsAt := strconv.FormatInt(a.StartsAt.Unix(), 10)
+	query := url.Values{}
+	query.Set("fp", a.Fingerprint)
+	query.Set("ts", startsAt)
+	query.Set("sig", sign(d.config.AckSecret.Value(), a.Fingerprint, startsAt))
+	a.AckURL = fmt.Sprintf("%s/ack?%s", strings.TrimRight(d.externalURL, "/"), query.Encode())
+	return a
+}
+
+func sign(secret string, fingerprint string, startsAt string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fingerprint + ":" + startsAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Сохранение и загрузка активных оповещений
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) saveState() {
+	if err := helper.SaveState(d.stateDir, stateFileName, d.active); err != nil {
+		log.Errorf("alert.saveState, ошибка сохранения состояния: %v", err)
+		return
+	}
+	d.dirty = false
+}
+
+func (d *dispatcher) loadState() {
+	if err := helper.LoadState(d.stateDir, stateFileName, &d.active); err != nil {
+		log.Errorf("alert.loadState, ошибка загрузки состояния: %v", err)
+		return
+	}
+	if d.active == nil {
+		d.active = make(map[string]*ActiveAlert)
+	}
+	log.Infof("alert.loadState, загружено активных оповещений: %d", len(d.active))
+}
@@ -0,0 +1,71 @@
+package alert
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+)
+
+// Ссылка подтверждает только ту проблему, для которой она выдана, в том числе при одновременном
+// повторном срабатывании оповещения
+func TestAcknowledgeSigned(t *testing.T) {
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	d = dispatcher{
+		config: helper.AlertingConfig{AckSecret: "secret"},
+		groups: make(map[string]*group),
+		active: make(map[string]*ActiveAlert),
+	}
+	defer func() { d = dispatcher{} }()
+	a := &Alert{Name: AlertServiceDown, Service: "api", Status: StatusFiring, StartsAt: start}
+	a.Fingerprint = fingerprint(a)
+	d.track(a, nil)
+
+	old := strconv.FormatInt(start.Add(-time.Hour).Unix(), 10)
+	current := strconv.FormatInt(start.Unix(), 10)
+	tests := []struct {
+		name      string
+		startsAt  string
+		signature string
+		want      error
+	}{
+		{"неверная подпись", current, sign("other", a.Fingerprint, current), ErrBadSignature},
+		{"ссылка на прошлую проблему", old, sign("secret", a.Fingerprint, old), ErrAlertNotFound},
+		{"ссылка на текущую проблему", current, sign("secret", a.Fingerprint, current), nil},
+	}
+	for _, test := range tests {
+		if err := AcknowledgeSigned(a.Fingerprint, test.startsAt, test.signature, "ops"); err != test.want {
+			t.Errorf("%s: ошибка %v, ожидается %v", test.name, err, test.want)
+		}
+	}
+	if active := Active(); len(active) != 1 || !active[0].Acked || active[0].AckedBy != "ops" {
+		t.Fatalf("активные оповещения %+v, ожидается подтверждённое", active)
+	}
+
+	// Отбой и повторное срабатывание параллельно с подтверждением старой ссылкой
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 100; i++ {
+			d.mutex.Lock()
+			d.track(&Alert{Name: a.Name, Service: a.Service, Fingerprint: a.Fingerprint, Status: StatusResolved}, nil)
+			d.track(&Alert{Name: a.Name, Service: a.Service, Fingerprint: a.Fingerprint, Status: StatusFiring,
+				StartsAt: start.Add(time.Duration(i) * time.Minute)}, nil)
+			d.mutex.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := AcknowledgeSigned(a.Fingerprint, current, sign("secret", a.Fingerprint, current), "ops"); err == nil {
+			d.mutex.Lock()
+			startsAt := d.active[a.Fingerprint].Alert.StartsAt
+			acked := d.active[a.Fingerprint].Acked
+			d.mutex.Unlock()
+			if acked && !startsAt.Equal(start) {
+				t.Fatalf("старая ссылка подтвердила проблему, начавшуюся %v", startsAt)
+			}
+		}
+	}
+	wg.Wait()
+}
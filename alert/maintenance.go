@@ -0,0 +1,197 @@
+package alert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+// Имя файла состояния тишины в каталоге состояния
+const silencesFileName = "silences.json"
+
+var ErrSilenceNotFound = errors.New("Тишина не найдена")
+
+// Silence - временное подавление оповещений, созданное через HTTP API
+type Silence struct {
+	ID        string            `json:"id"`
+	Services  []string          `json:"services,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка, находится ли сервис в окне обслуживания или под действием тишины.
+// Возвращает имя окна или идентификатор тишины.
+//----------------------------------------------------------------------------------------------------------------------
+func InMaintenance(service string, tags map[string]string, now time.Time) (bool, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.inMaintenance(service, tags, now)
+}
+
+func (d *dispatcher) inMaintenance(service string, tags map[string]string, now time.Time) (bool, string) {
+	for _, window := range d.maintenance {
+		if scopeMatches(window.Services, window.Tags, service, tags) && windowActive(window, now) {
+			return true, window.Name
+		}
+	}
+	for _, silence := range d.silences {
+		if !now.Before(silence.StartsAt) && now.Before(silence.EndsAt) &&
+			scopeMatches(silence.Services, silence.Tags, service, tags) {
+			return true, "silence " + silence.ID
+		}
+	}
+	return false, ""
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка области действия окна: по именам сервисов или по меткам.
+// Окно без списка сервисов и меток действует на все сервисы.
+//----------------------------------------------------------------------------------------------------------------------
+func scopeMatches(services []string, scopeTags map[string]string, service string, tags map[string]string) bool {
+	if len(services) > 0 {
+		found := false
+		for _, name := range services {
+			if name == service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for name, value := range scopeTags {
+		if tags[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка активности окна обслуживания в указанный момент
+//----------------------------------------------------------------------------------------------------------------------
+func windowActive(window helper.MaintenanceWindow, now time.Time) bool {
+	if window.Start != "" || window.End != "" {
+		start, err := helper.ParseWindowTime(window.Start, now.Location())
+		if err != nil {
+			return false
+		}
+		end, err := helper.ParseWindowTime(window.End, now.Location())
+		if err != nil {
+			return false
+		}
+		if !now.Before(start) && now.Before(end) {
+			return true
+		}
+	}
+	if window.Schedule != "" && window.Duration > 0 {
+		schedule, err := helper.ParseSchedule(window.Schedule)
+		if err != nil {
+			return false
+		}
+		// Окно активно, если расписание срабатывало позже, чем duration назад
+		next := schedule.Next(now.Add(-window.Duration.Duration()))
+		if !next.IsZero() && !next.After(now) {
+			return true
+		}
+	}
+	for _, timeRange := range window.TimeRanges {
+		if timeRange.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Список действующих и запланированных тишин
+//----------------------------------------------------------------------------------------------------------------------
+func Silences() []Silence {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	list := make([]Silence, 0, len(d.silences))
+	for _, silence := range d.silences {
+		list = append(list, *silence)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartsAt.Before(list[j].StartsAt) })
+	return list
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Создание тишины
+//----------------------------------------------------------------------------------------------------------------------
+func AddSilence(silence Silence) (Silence, error) {
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = time.Now()
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return silence, errors.New("Время окончания тишины должно быть позже времени начала")
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return silence, err
+	}
+	silence.ID = hex.EncodeToString(id)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.silences[silence.ID] = &silence
+	d.saveSilences()
+	log.Infof("alert.AddSilence, тишина %s до %s: %s", silence.ID, silence.EndsAt.Format("2006-01-02 15:04:05"), silence.Comment)
+	return silence, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Удаление тишины
+//----------------------------------------------------------------------------------------------------------------------
+func DeleteSilence(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.silences[id]; !ok {
+		return ErrSilenceNotFound
+	}
+	delete(d.silences, id)
+	d.saveSilences()
+	log.Infof("alert.DeleteSilence, тишина %s удалена", id)
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Удаление истёкших тишин
+//----------------------------------------------------------------------------------------------------------------------
+func (d *dispatcher) expireSilences(now time.Time) {
+	expired := false
+	for id, silence := range d.silences {
+		if !now.Before(silence.EndsAt) {
+			delete(d.silences, id)
+			expired = true
+		}
+	}
+	if expired {
+		d.saveSilences()
+	}
+}
+
+func (d *dispatcher) saveSilences() {
+	if err := helper.SaveState(d.stateDir, silencesFileName, d.silences); err != nil {
+		log.Errorf("alert.saveSilences, ошибка сохранения тишины: %v", err)
+	}
+}
+
+func (d *dispatcher) loadSilences() {
+	if err := helper.LoadState(d.stateDir, silencesFileName, &d.silences); err != nil {
+		log.Errorf("alert.loadSilences, ошибка загрузки тишины: %v", err)
+	}
+	if d.silences == nil {
+		d.silences = make(map[string]*Silence)
+	}
+}
@@ -0,0 +1,59 @@
+package alert
+
+import (
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+)
+
+// Сервис недоступен до начала окна обслуживания: пока окно действует, оповещение не повторяется
+// и не эскалируется, после окончания окна отправка продолжается
+func TestDownThenMaintenanceWindow(t *testing.T) {
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	dp := &dispatcher{
+		config: helper.AlertingConfig{
+			Route:     &helper.Route{Receiver: "team", Escalation: "pager"},
+			Receivers: []helper.Receiver{{Name: "team"}, {Name: "oncall"}, {Name: "manager"}},
+			EscalationPolicies: []helper.EscalationPolicy{{Name: "pager", Tiers: []helper.EscalationTier{
+				{Receiver: "oncall"},
+				{Delay: helper.Duration(30 * time.Minute), Receiver: "manager"},
+			}}},
+		},
+		maintenance: []helper.MaintenanceWindow{{Name: "upgrade", Services: []string{"api"},
+			Start: start.Add(10 * time.Minute).Format("2006-01-02 15:04"),
+			End:   start.Add(time.Hour).Format("2006-01-02 15:04")}},
+		groups: make(map[string]*group),
+		active: make(map[string]*ActiveAlert),
+	}
+	a := &Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical, Status: StatusFiring, StartsAt: start}
+	a.Fingerprint = fingerprint(a)
+	dp.fire(a, start)
+	g := dp.groups["0:{}"]
+	if g == nil {
+		t.Fatalf("нет группы, группы: %v", dp.groups)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		alerts    int      // оповещений в сообщении группы
+		escalated []string // получатели эскалации
+	}{
+		{"до окна", start, 1, []string{"oncall"}},
+		{"окно началось", start.Add(15 * time.Minute), 0, nil},
+		{"срок второго уровня в окне", start.Add(45 * time.Minute), 0, nil},
+		{"окно закончилось", start.Add(time.Hour), 1, []string{"manager"}},
+	}
+	for _, test := range tests {
+		if n := dp.notification(g, test.now); len(n.Alerts) != test.alerts {
+			t.Errorf("%s: в сообщении %d оповещений, ожидается %d", test.name, len(n.Alerts), test.alerts)
+		}
+		var escalated []string
+		for _, item := range dp.escalate(test.now) {
+			escalated = append(escalated, item.receiver.Name)
+		}
+		if len(escalated) != len(test.escalated) || (len(escalated) > 0 && escalated[0] != test.escalated[0]) {
+			t.Errorf("%s: эскалация %v, ожидается %v", test.name, escalated, test.escalated)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отправка сообщения всеми способами, настроенными у получателя
+//----------------------------------------------------------------------------------------------------------------------
+func send(receiver helper.Receiver, n *Notification) {
+	log.Infof("alert.send, отправка получателю %s: %s, оповещений %d", receiver.Name, n.Status, len(n.Alerts))
+	for _, webhook := range receiver.Webhooks {
+		if err := sendWebhook(webhook, n); err != nil {
+			log.Errorf("alert.send, ошибка отправки на %s: %v", webhook.URL, err)
+		}
+	}
+	for _, email := range receiver.Emails {
+		if err := sendEmail(email, n); err != nil {
+			log.Errorf("alert.send, ошибка отправки почты через %s: %v", email.SmartHost, err)
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отправка сообщения POST-запросом в формате JSON
+//----------------------------------------------------------------------------------------------------------------------
+func sendWebhook(webhook helper.WebhookConfig, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(webhook.URL, "application/json", bytes.NewReader(helper.RedactSecretsBytes(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ответ %s", resp.Status)
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отправка сообщения по электронной почте
+//----------------------------------------------------------------------------------------------------------------------
+func sendEmail(email helper.EmailConfig, n *Notification) error {
+	var auth smtp.Auth
+	if email.Username != "" {
+		host, _, err := net.SplitHostPort(email.SmartHost)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", email.Username, email.Password.Value(), host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", email.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject(n))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	for _, a := range n.Alerts {
+		fmt.Fprintf(&msg, "[%s] %s %s (%s): %s\r\n", strings.ToUpper(a.Status), a.Name, a.Service, a.Severity, a.Summary)
+		fmt.Fprintf(&msg, "    адрес: %s, начало: %s\r\n", a.Address, a.StartsAt.Format("2006-01-02 15:04:05"))
+		if a.AckURL != "" {
+			fmt.Fprintf(&msg, "    подтвердить: %s\r\n", a.AckURL)
+		}
+	}
+	return smtp.SendMail(email.SmartHost, auth, email.From, email.To, helper.RedactSecretsBytes(msg.Bytes()))
+}
+
+func subject(n *Notification) string {
+	firing := 0
+	for _, a := range n.Alerts {
+		if a.Status == StatusFiring {
+			firing++
+		}
+	}
+	return fmt.Sprintf("[%s:%d] ws_monitoring %s", strings.ToUpper(n.Status), firing, labelsKey(n.GroupLabels))
+}
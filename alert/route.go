@@ -0,0 +1,132 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"ws_monitoring/helper"
+)
+
+// Значения по умолчанию для параметров группировки, в секундах
+const (
+	defaultGroupWait      = 30
+	defaultGroupInterval  = 300
+	defaultRepeatInterval = 4 * 3600
+)
+
+// Тип - маршрут, для которого вычислены унаследованные от родителей параметры
+type matchedRoute struct {
+	path           string
+	receiver       string
+	groupBy        []string
+	groupWait      time.Duration
+	groupInterval  time.Duration
+	repeatInterval time.Duration
+	escalation     string
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Поиск маршрутов, соответствующих оповещению.
+// Обход дерева в глубину: первый совпавший дочерний маршрут прекращает поиск среди соседей,
+// если у него не установлен флаг continue. Если ни один дочерний маршрут не совпал, используется родительский.
+//----------------------------------------------------------------------------------------------------------------------
+func matchRoutes(root *helper.Route, a *Alert, now time.Time) []*matchedRoute {
+	if root == nil {
+		return nil
+	}
+	parent := &matchedRoute{
+		groupWait:      defaultGroupWait * time.Second,
+		groupInterval:  defaultGroupInterval * time.Second,
+		repeatInterval: defaultRepeatInterval * time.Second,
+	}
+	// Корневой маршрут соответствует любому оповещению
+	return walkRoute(root, inherit(parent, root, "0"), a, now)
+}
+
+func walkRoute(route *helper.Route, current *matchedRoute, a *Alert, now time.Time) []*matchedRoute {
+	var result []*matchedRoute
+	for i, child := range route.Routes {
+		if !routeMatches(child, a, now) {
+			continue
+		}
+		result = append(result, walkRoute(child, inherit(current, child, fmt.Sprintf("%s.%d", current.path, i)), a, now)...)
+		if !child.Continue {
+			break
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Наследование параметров маршрута от родителя
+//----------------------------------------------------------------------------------------------------------------------
+func inherit(parent *matchedRoute, route *helper.Route, path string) *matchedRoute {
+	m := *parent
+	m.path = path
+	if route.Receiver != "" {
+		m.receiver = route.Receiver
+	}
+	if len(route.GroupBy) > 0 {
+		m.groupBy = route.GroupBy
+	}
+	if route.GroupWait > 0 {
+		m.groupWait = route.GroupWait.Duration()
+	}
+	if route.GroupInterval > 0 {
+		m.groupInterval = route.GroupInterval.Duration()
+	}
+	if route.RepeatInterval > 0 {
+		m.repeatInterval = route.RepeatInterval.Duration()
+	}
+	if route.Escalation != "" {
+		m.escalation = route.Escalation
+	}
+	return &m
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка условий маршрута
+//----------------------------------------------------------------------------------------------------------------------
+func routeMatches(route *helper.Route, a *Alert, now time.Time) bool {
+	labels := a.Labels()
+	for name, value := range route.Match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	for name := range route.MatchRE {
+		// Выражения компилируются при проверке конфигурации, неверное выражение не совпадает ни с чем
+		re := route.MatchRegexp[name]
+		if re == nil || !re.MatchString(labels[name]) {
+			return false
+		}
+	}
+	if len(route.Severity) > 0 {
+		found := false
+		for _, severity := range route.Severity {
+			if strings.EqualFold(severity, a.Severity) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(route.ActiveTime) > 0 {
+		active := false
+		for _, timeRange := range route.ActiveTime {
+			if timeRange.Contains(now) {
+				active = true
+				break
+			}
+		}
+		if !active {
+			return false
+		}
+	}
+	return true
+}
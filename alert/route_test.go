@@ -0,0 +1,188 @@
+package alert
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitConsoleLogger(ioutil.Discard, "debug")
+	os.Exit(m.Run())
+}
+
+// Дерево маршрутов для тестов: выражения match_re компилируются так же, как при проверке конфигурации
+func testRoutes(t *testing.T) *helper.Route {
+	root := &helper.Route{
+		Receiver: "default",
+		GroupBy:  []string{"alertname"},
+		Routes: []*helper.Route{
+			{Receiver: "db", MatchRE: map[string]string{"service": "db-.*"}, Continue: true},
+			{Receiver: "critical", Severity: []string{"critical"}, GroupBy: []string{"service"},
+				Routes: []*helper.Route{
+					{Receiver: "night", ActiveTime: []helper.TimeRange{{Start: "22:00", End: "08:00"}}},
+				}},
+			{Receiver: "team", Match: map[string]string{"team": "web"}},
+		},
+	}
+	var compile func(route *helper.Route)
+	compile = func(route *helper.Route) {
+		route.MatchRegexp = make(map[string]*regexp.Regexp)
+		for name, expr := range route.MatchRE {
+			re, err := helper.CompileMatchRE(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			route.MatchRegexp[name] = re
+		}
+		for _, child := range route.Routes {
+			compile(child)
+		}
+	}
+	compile(root)
+	return root
+}
+
+func TestMatchRoutes(t *testing.T) {
+	day := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	night := time.Date(2024, 3, 4, 23, 0, 0, 0, time.Local)
+	tests := []struct {
+		name  string
+		alert Alert
+		now   time.Time
+		want  []string // получатель@путь маршрута
+	}{
+		{"ни один маршрут не совпал", Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityWarning}, day,
+			[]string{"default@0"}},
+		{"регулярное выражение и continue", Alert{Name: AlertServiceDown, Service: "db-main", Severity: SeverityCritical}, day,
+			[]string{"db@0.0", "critical@0.1"}},
+		{"выражение совпадает целиком", Alert{Name: AlertServiceDown, Service: "old-db-main", Severity: SeverityWarning}, day,
+			[]string{"default@0"}},
+		{"вложенный маршрут ночью", Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical}, night,
+			[]string{"night@0.1.0"}},
+		{"первый совпавший прекращает поиск", Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical,
+			Tags: map[string]string{"team": "web"}}, day, []string{"critical@0.1"}},
+		{"совпадение по тегу", Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityWarning,
+			Tags: map[string]string{"team": "web"}}, day, []string{"team@0.2"}},
+	}
+	root := testRoutes(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, route := range matchRoutes(root, &test.alert, test.now) {
+				got = append(got, route.receiver+"@"+route.path)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("маршруты %v, ожидается %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchRoutesInherit(t *testing.T) {
+	root := testRoutes(t)
+	root.GroupWait = helper.Duration(10 * time.Second)
+	a := &Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical}
+	routes := matchRoutes(root, a, time.Date(2024, 3, 4, 23, 0, 0, 0, time.Local))
+	if len(routes) != 1 {
+		t.Fatalf("маршрутов %d, ожидается 1", len(routes))
+	}
+	route := routes[0]
+	if route.groupWait != 10*time.Second || route.groupInterval != defaultGroupInterval*time.Second {
+		t.Errorf("group_wait %v, group_interval %v: параметры не унаследованы", route.groupWait, route.groupInterval)
+	}
+	if !reflect.DeepEqual(route.groupBy, []string{"service"}) {
+		t.Errorf("group_by %v, ожидается [service] от родителя", route.groupBy)
+	}
+}
+
+func TestGroupLabels(t *testing.T) {
+	a := &Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical, Tags: map[string]string{"team": "web"}}
+	tests := []struct {
+		groupBy []string
+		want    string
+	}{
+		{nil, "{}"},
+		{[]string{"service"}, "{service=api}"},
+		{[]string{"team", "alertname"}, "{alertname=ServiceDown,team=web}"},
+		{[]string{"missing"}, "{missing=}"},
+		{[]string{"..."}, "{alertname=ServiceDown,service=api,severity=critical,team=web}"},
+	}
+	for _, test := range tests {
+		if got := labelsKey(groupLabels(test.groupBy, a)); got != test.want {
+			t.Errorf("группировка по %v: %s, ожидается %s", test.groupBy, got, test.want)
+		}
+	}
+}
+
+func TestFireGroupsAlerts(t *testing.T) {
+	dp := &dispatcher{
+		config: helper.AlertingConfig{Route: testRoutes(t)},
+		groups: make(map[string]*group),
+		active: make(map[string]*ActiveAlert),
+	}
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	for _, service := range []string{"api", "web", "api"} {
+		a := &Alert{Name: AlertServiceDown, Service: service, Severity: SeverityWarning, Status: StatusFiring}
+		a.Fingerprint = fingerprint(a)
+		dp.fire(a, now)
+	}
+	if len(dp.groups) != 1 {
+		t.Fatalf("групп %d, ожидается 1: оповещения группируются по alertname", len(dp.groups))
+	}
+	g := dp.groups["0:{alertname=ServiceDown}"]
+	if g == nil || len(g.alerts) != 2 {
+		t.Fatalf("группа %+v, ожидается два оповещения", g)
+	}
+	if !g.nextFlush.Equal(now.Add(defaultGroupWait * time.Second)) {
+		t.Errorf("отправка группы %v, ожидается через group_wait", g.nextFlush)
+	}
+}
+
+// Отбой отправляется в группу, в которую оповещение попало при срабатывании, даже если
+// сейчас оно было бы направлено по другому маршруту
+func TestResolveUsesGroupsFromFiring(t *testing.T) {
+	dp := &dispatcher{
+		config: helper.AlertingConfig{Route: testRoutes(t)},
+		groups: make(map[string]*group),
+		active: make(map[string]*ActiveAlert),
+	}
+	night := time.Date(2024, 3, 4, 23, 0, 0, 0, time.Local)
+	a := &Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical, Status: StatusFiring, StartsAt: night}
+	a.Fingerprint = fingerprint(a)
+	dp.fire(a, night)
+
+	key := "0.1.0:{service=api}"
+	g := dp.groups[key]
+	if g == nil {
+		t.Fatalf("нет группы %s, группы: %v", key, dp.groups)
+	}
+	if refs := dp.active[a.Fingerprint].Groups; !reflect.DeepEqual(refs, []groupRef{{Route: "0.1.0", Key: key}}) {
+		t.Errorf("запомнены группы %v", refs)
+	}
+	g.changed = false
+	g.lastNotify = night
+
+	// Утром маршрут night не действует, но отбой должен прийти тому же получателю
+	resolved := &Alert{Name: AlertServiceDown, Service: "api", Severity: SeverityCritical, Status: StatusResolved,
+		Fingerprint: a.Fingerprint}
+	dp.resolve(resolved)
+	if len(dp.groups) != 1 {
+		t.Errorf("групп %d, отбой не должен создавать новые группы", len(dp.groups))
+	}
+	got := g.alerts[a.Fingerprint]
+	if got.Status != StatusResolved || !got.StartsAt.Equal(night) || !g.changed {
+		t.Errorf("в группе %+v, ожидается отбой с временем начала %v", got, night)
+	}
+	if !g.nextFlush.Equal(night.Add(defaultGroupInterval * time.Second)) {
+		t.Errorf("отправка отбоя %v, ожидается через group_interval после предыдущей", g.nextFlush)
+	}
+	if _, ok := dp.active[a.Fingerprint]; ok {
+		t.Error("оповещение осталось активным после отбоя")
+	}
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+	"ws_monitoring/slo"
+	"ws_monitoring/workmanager"
+)
+
+// Тип - HTTP API для просмотра состояния и подтверждения оповещений
+type server struct {
+	mutex  sync.RWMutex
+	token  string
+	listen string
+	http   *http.Server
+}
+
+var srv server
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск HTTP API. Если адрес не задан в конфигурации, API не запускается.
+//----------------------------------------------------------------------------------------------------------------------
+func Startup(cfg *helper.Config) {
+	if cfg.HTTPListen == "" {
+		log.Info("api.Startup, адрес HTTP API не задан")
+		return
+	}
+	log.Infof("api.Startup, запуск HTTP API на %s", cfg.HTTPListen)
+
+	srv.listen = cfg.HTTPListen
+	srv.token = cfg.APIToken.Value()
+	if srv.token == "" {
+		log.Info("api.Startup, токен api_token не задан, изменяющие запросы к HTTP API запрещены")
+	}
+	srv.http = &http.Server{
+		Addr:         cfg.HTTPListen,
+		Handler:      srv.handler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		if err := srv.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("api.Startup, ошибка HTTP API: %v", err)
+		}
+	}()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Маршруты HTTP API. Изменяющие запросы проходят проверку токена.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", handleStatus)
+	mux.HandleFunc("/api/config", handleConfig)
+	mux.HandleFunc("/api/alerts", handleAlerts)
+	mux.HandleFunc("/api/alerts/ack", s.authorized(handleAck))
+	mux.HandleFunc("/ack", handleSignedAck)
+	mux.HandleFunc("/api/silences", s.authorized(handleSilences))
+	mux.HandleFunc("/api/slo", handleSLO)
+	mux.HandleFunc("/metrics", handleMetrics)
+	return mux
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение новой конфигурации. Смена адреса требует перезапуска программы.
+//----------------------------------------------------------------------------------------------------------------------
+func Reload(cfg *helper.Config) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.token = cfg.APIToken.Value()
+	if srv.http != nil && cfg.HTTPListen != srv.listen {
+		log.Errorf("api.Reload, смена адреса HTTP API (%s) вступит в силу после перезапуска", cfg.HTTPListen)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка HTTP API
+//----------------------------------------------------------------------------------------------------------------------
+func Shutdown() {
+	if srv.http == nil {
+		return
+	}
+	log.Info("api.Shutdown, остановка HTTP API")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.http.Shutdown(ctx); err != nil {
+		log.Errorf("api.Shutdown, ошибка остановки HTTP API: %v", err)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка токена для изменяющих запросов. Запросы GET выполняются без токена.
+// Если токен не задан в конфигурации, изменяющие запросы запрещены.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.RLock()
+		token := s.token
+		s.mutex.RUnlock()
+		if r.Method != http.MethodGet {
+			if token == "" {
+				writeError(w, http.StatusForbidden, "Не задан токен api_token, изменяющие запросы запрещены")
+				return
+			}
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "Неверный токен")
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// GET /api/status - состояние рабочих потоков
+//----------------------------------------------------------------------------------------------------------------------
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, workmanager.Status())
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// GET /api/config - результат последней загрузки конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, workmanager.Configuration())
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// GET /api/alerts - активные оповещения
+//----------------------------------------------------------------------------------------------------------------------
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, alert.Active())
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// POST /api/alerts/ack - подтверждение оповещения, параметры fingerprint и by
+//----------------------------------------------------------------------------------------------------------------------
+func handleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Допустим только POST")
+		return
+	}
+	var request struct {
+		Fingerprint string `json:"fingerprint"`
+		By          string `json:"by"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		request.Fingerprint = r.FormValue("fingerprint")
+		request.By = r.FormValue("by")
+	}
+	if request.By == "" {
+		request.By = "api"
+	}
+	if err := alert.Acknowledge(request.Fingerprint, request.By); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "acknowledged"})
+}
+
+// Страница подтверждения по ссылке из сообщения. Оповещение подтверждается только отправкой формы:
+// сканеры ссылок в почте и чатах и предзагрузка в браузере выполняют GET и не должны подтверждать оповещения.
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Подтверждение оповещения</title></head>
+<body>
+<form method="post" action="ack">
+<input type="hidden" name="fp" value="{{.fp}}">
+<input type="hidden" name="ts" value="{{.ts}}">
+<input type="hidden" name="sig" value="{{.sig}}">
+<button type="submit">Подтвердить оповещение</button>
+</form>
+</body>
+</html>
+`))
+
+//----------------------------------------------------------------------------------------------------------------------
+// /ack - подтверждение оповещения по подписанной ссылке из сообщения:
+// GET - страница подтверждения, POST с параметрами ссылки fp, ts, sig - подтверждение
+//----------------------------------------------------------------------------------------------------------------------
+func handleSignedAck(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		params := map[string]string{"fp": query.Get("fp"), "ts": query.Get("ts"), "sig": query.Get("sig")}
+		if err := ackPage.Execute(w, params); err != nil {
+			log.Errorf("api.handleSignedAck, ошибка записи ответа: %v", err)
+		}
+		return
+	case http.MethodPost:
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Допустимы GET и POST")
+		return
+	}
+	err := alert.AcknowledgeSigned(r.PostFormValue("fp"), r.PostFormValue("ts"), r.PostFormValue("sig"), "link "+r.RemoteAddr)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch err {
+	case nil:
+		fmt.Fprintln(w, "Оповещение подтверждено")
+	case alert.ErrBadSignature:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// GET /api/slo - показатели SLO и остаток бюджета ошибок
+//----------------------------------------------------------------------------------------------------------------------
+func handleSLO(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, slo.Reports())
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// GET /metrics - метрики в текстовом формате Prometheus
+//----------------------------------------------------------------------------------------------------------------------
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.Write(w); err != nil {
+		log.Errorf("api.handleMetrics, ошибка записи метрик: %v", err)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// /api/silences - тишина для оповещений:
+// GET - список, POST - создание (JSON alert.Silence), DELETE ?id= - удаление
+//----------------------------------------------------------------------------------------------------------------------
+func handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, alert.Silences())
+	case http.MethodPost:
+		var silence alert.Silence
+		if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		silence, err := alert.AddSilence(silence)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, silence)
+	case http.MethodDelete:
+		if err := alert.DeleteSilence(r.URL.Query().Get("id")); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Допустимы GET, POST и DELETE")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	// Ответ проверяется на значения секретов, например пароль в адресе сервиса
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("api.writeJSON, ошибка формирования ответа: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(helper.RedactSecretsBytes(body), '\n')); err != nil {
+		log.Errorf("api.writeJSON, ошибка записи ответа: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
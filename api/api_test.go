@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"ws_monitoring/alert"
+)
+
+func TestSignedAckGetShowsForm(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/ack?fp=abc&ts=123&sig=def", nil)
+	recorder := httptest.NewRecorder()
+	handleSignedAck(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("код ответа %d, ожидается %d", recorder.Code, http.StatusOK)
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{`method="post"`, `name="fp" value="abc"`, `name="ts" value="123"`, `name="sig" value="def"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("в странице нет %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestSignedAckPostChecksSignature(t *testing.T) {
+	form := url.Values{"fp": {"abc"}, "ts": {"123"}, "sig": {"def"}}
+	request := httptest.NewRequest(http.MethodPost, "/ack", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	handleSignedAck(recorder, request)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("код ответа %d, ожидается %d", recorder.Code, http.StatusForbidden)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		header string
+		want   int
+	}{
+		{"GET без токена", "", http.MethodGet, "", http.StatusOK},
+		{"POST без настроенного токена", "", http.MethodPost, "", http.StatusForbidden},
+		{"POST без заголовка", "secret", http.MethodPost, "", http.StatusUnauthorized},
+		{"POST с неверным токеном", "secret", http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{"POST с токеном", "secret", http.MethodPost, "Bearer secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &server{token: test.token}
+			handler := s.authorized(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+			request := httptest.NewRequest(test.method, "/api/alerts/ack", nil)
+			if test.header != "" {
+				request.Header.Set("Authorization", test.header)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, request)
+			if recorder.Code != test.want {
+				t.Errorf("код ответа %d, ожидается %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestSilencesRequireToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		target string
+		want   int
+	}{
+		{"POST без настроенного токена", "", http.MethodPost, "/api/silences", http.StatusForbidden},
+		{"DELETE без настроенного токена", "", http.MethodDelete, "/api/silences?id=1", http.StatusForbidden},
+		{"POST без заголовка", "secret", http.MethodPost, "/api/silences", http.StatusUnauthorized},
+		{"DELETE без заголовка", "secret", http.MethodDelete, "/api/silences?id=1", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &server{token: test.token}
+			body := strings.NewReader(`{"services": ["*"], "comment": "test"}`)
+			request := httptest.NewRequest(test.method, test.target, body)
+			recorder := httptest.NewRecorder()
+			s.handler().ServeHTTP(recorder, request)
+			if recorder.Code != test.want {
+				t.Errorf("код ответа %d, ожидается %d", recorder.Code, test.want)
+			}
+			if silences := alert.Silences(); len(silences) != 0 {
+				t.Errorf("создана тишина без токена: %+v", silences)
+			}
+		})
+	}
+}
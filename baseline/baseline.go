@@ -0,0 +1,285 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+const (
+	stateFileName = "baseline.json"
+	saveInterval  = time.Minute
+	alertName     = "LatencyDegraded"
+
+	// Значения по умолчанию для параметров обнаружения
+	defaultThreshold  = 3
+	defaultSustained  = 5
+	defaultAlpha      = 0.05
+	defaultMinSamples = 100
+
+	// Число проверок в часе недели, после которого используется сезонный профиль
+	minSeasonalSamples = 10
+	// Нижняя граница стандартного отклонения относительно среднего, чтобы
+	// стабильный сервис не считался деградировавшим из-за колебаний в миллисекунды
+	minRelativeDeviation = 0.1
+	// Во время отклонения базовая линия обучается медленнее, чтобы не привыкнуть к деградации
+	deviationAlphaFactor = 0.1
+)
+
+// Тип - экспоненциально сглаженные среднее и дисперсия
+type ewma struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Samples  int     `json:"samples"`
+}
+
+// Тип - базовая линия времени ответа сервиса: общая и по часам недели
+type model struct {
+	Global     ewma      `json:"global"`
+	Seasonal   [168]ewma `json:"seasonal"`
+	Deviations int       `json:"deviations"` // проверок подряд с отклонением
+	Normal     int       `json:"normal"`     // проверок подряд без отклонения
+	Degraded   bool      `json:"degraded"`
+	DegradedAt time.Time `json:"degraded_at,omitempty"`
+}
+
+// detector - синглтон, хранит базовые линии сервисов
+type detector struct {
+	mutex           sync.Mutex
+	stateDir        string
+	settings        map[string]*helper.AnomalyConfig
+	models          map[string]*model
+	dirty           bool
+	ShutdownChannel chan string
+}
+
+var d detector
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск обнаружения аномалий, загрузка сохранённых базовых линий
+//----------------------------------------------------------------------------------------------------------------------
+func Startup(cfg *helper.Config) {
+	log.Info("baseline.Startup, Started")
+	d = detector{
+		stateDir:        cfg.StateDir,
+		settings:        settings(cfg),
+		models:          make(map[string]*model),
+		ShutdownChannel: make(chan string),
+	}
+	if err := helper.LoadState(d.stateDir, stateFileName, &d.models); err != nil {
+		log.Errorf("baseline.Startup, ошибка загрузки базовых линий: %v", err)
+	}
+	if d.models == nil {
+		d.models = make(map[string]*model)
+	}
+	go d.loop()
+	log.Info("baseline.Startup, Completed")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение новой конфигурации, выученные базовые линии сохраняются
+//----------------------------------------------------------------------------------------------------------------------
+func Reload(cfg *helper.Config) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.settings = settings(cfg)
+	if d.stateDir != cfg.StateDir {
+		d.stateDir = cfg.StateDir
+		d.dirty = true
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка с сохранением базовых линий
+//----------------------------------------------------------------------------------------------------------------------
+func Shutdown() {
+	log.Info("baseline.Shutdown, Started")
+	d.ShutdownChannel <- "Down"
+	<-d.ShutdownChannel
+	close(d.ShutdownChannel)
+	d.mutex.Lock()
+	d.save()
+	d.mutex.Unlock()
+	log.Info("baseline.Shutdown, Completed")
+}
+
+func settings(cfg *helper.Config) map[string]*helper.AnomalyConfig {
+	list := make(map[string]*helper.AnomalyConfig)
+	for _, service := range cfg.Services {
+		if service.Anomaly == nil {
+			continue
+		}
+		s := *service.Anomaly
+		if s.Threshold <= 0 {
+			s.Threshold = defaultThreshold
+		}
+		if s.Sustained <= 0 {
+			s.Sustained = defaultSustained
+		}
+		if s.Alpha <= 0 || s.Alpha >= 1 {
+			s.Alpha = defaultAlpha
+		}
+		if s.MinSamples <= 0 {
+			s.MinSamples = defaultMinSamples
+		}
+		list[service.Name] = &s
+	}
+	return list
+}
+
+func (d *detector) loop() {
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ShutdownChannel:
+			d.ShutdownChannel <- "Down"
+			return
+		case <-ticker.C:
+			d.mutex.Lock()
+			if d.dirty {
+				d.save()
+			}
+			d.mutex.Unlock()
+		}
+	}
+}
+
+func (d *detector) save() {
+	if err := helper.SaveState(d.stateDir, stateFileName, d.models); err != nil {
+		log.Errorf("baseline.save, ошибка сохранения базовых линий: %v", err)
+		return
+	}
+	d.dirty = false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Учёт времени ответа успешной проверки. Для сервисов без настроек обнаружения ничего не делает.
+// Деградация фиксируется, когда время ответа превышает ожидаемое на threshold стандартных отклонений
+// sustained проверок подряд, и снимается после стольких же проверок подряд без отклонения.
+// Возвращает true, если время ответа сервиса деградировало.
+//----------------------------------------------------------------------------------------------------------------------
+func Observe(service string, address string, tags map[string]string, checkTime time.Time, duration time.Duration) bool {
+	d.mutex.Lock()
+	s, ok := d.settings[service]
+	if !ok {
+		d.mutex.Unlock()
+		return false
+	}
+	m, ok := d.models[service]
+	if !ok {
+		m = &model{}
+		d.models[service] = m
+	}
+	value := duration.Seconds()
+	slot := &m.Seasonal[hourOfWeek(checkTime)]
+	expected := &m.Global
+	if slot.Samples >= minSeasonalSamples {
+		expected = slot
+	}
+
+	score := 0.0
+	learning := m.Global.Samples < s.MinSamples
+	if !learning {
+		deviation := math.Max(math.Sqrt(expected.Variance), expected.Mean*minRelativeDeviation)
+		if deviation > 0 {
+			score = (value - expected.Mean) / deviation
+		}
+	}
+	deviating := score > s.Threshold
+	mean := expected.Mean
+
+	alpha := s.Alpha
+	if deviating {
+		alpha *= deviationAlphaFactor
+		m.Deviations++
+		m.Normal = 0
+	} else {
+		m.Normal++
+		m.Deviations = 0
+	}
+	m.Global.update(value, alpha)
+	slot.update(value, alpha)
+	d.dirty = true
+
+	var a *alert.Alert
+	if !m.Degraded && m.Deviations >= s.Sustained {
+		m.Degraded = true
+		m.DegradedAt = checkTime
+		a = &alert.Alert{
+			Status: alert.StatusFiring,
+			Summary: fmt.Sprintf("Время ответа %.3f с при ожидаемом %.3f с (отклонение %.1f сигм, проверок подряд: %d)",
+				value, mean, score, m.Deviations),
+		}
+		log.Infof("baseline.Observe, сервис %s: деградация времени ответа, %s", service, a.Summary)
+	} else if m.Degraded && m.Normal >= s.Sustained {
+		m.Degraded = false
+		a = &alert.Alert{
+			Status:  alert.StatusResolved,
+			EndsAt:  checkTime,
+			Summary: "Время ответа вернулось к обычному",
+		}
+		log.Infof("baseline.Observe, сервис %s: время ответа вернулось к обычному", service)
+	}
+	if a != nil {
+		a.Name = alertName
+		a.Service = service
+		a.Address = address
+		a.Tags = tags
+		a.Severity = alert.SeverityWarning
+		a.StartsAt = m.DegradedAt
+	}
+	degraded := 0.0
+	if m.Degraded {
+		degraded = 1
+	}
+	d.mutex.Unlock()
+
+	labels := map[string]string{"service": service}
+	metrics.SetGauge("ws_latency_baseline_seconds", "Ожидаемое время ответа по базовой линии", labels, mean)
+	metrics.SetGauge("ws_latency_anomaly_score", "Отклонение времени ответа от базовой линии, в сигмах", labels, score)
+	metrics.SetGauge("ws_latency_degraded", "Деградация времени ответа: 1 - обнаружена", labels, degraded)
+	if a != nil {
+		alert.Notify(a)
+	}
+	return degraded > 0
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Деградация времени ответа сервиса по базовой линии, сохранённой в каталоге состояния.
+// Используется однократными командами, которые не ведут базовую линию сами.
+//----------------------------------------------------------------------------------------------------------------------
+func SavedDegraded(stateDir string, service string) (bool, error) {
+	var models map[string]*model
+	if err := helper.LoadState(stateDir, stateFileName, &models); err != nil {
+		return false, err
+	}
+	m, ok := models[service]
+	return ok && m.Degraded, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Обновление экспоненциально сглаженных среднего и дисперсии
+//----------------------------------------------------------------------------------------------------------------------
+func (e *ewma) update(value float64, alpha float64) {
+	if e.Samples == 0 {
+		e.Mean = value
+		e.Variance = 0
+	} else {
+		diff := value - e.Mean
+		increment := alpha * diff
+		e.Mean += increment
+		e.Variance = (1 - alpha) * (e.Variance + diff*increment)
+	}
+	e.Samples++
+}
+
+// Номер часа недели: 0 - полночь понедельника
+func hourOfWeek(t time.Time) int {
+	return ((int(t.Weekday())+6)%7)*24 + t.Hour()
+}
@@ -0,0 +1,79 @@
+package baseline
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitConsoleLogger(ioutil.Discard, "error")
+	alert.Startup(&helper.Config{})
+	os.Exit(m.Run())
+}
+
+func TestObserveDegraded(t *testing.T) {
+	d = detector{
+		stateDir: t.TempDir(),
+		settings: map[string]*helper.AnomalyConfig{"api": {Threshold: 3, Sustained: 3, Alpha: 0.1, MinSamples: 10}},
+		models:   make(map[string]*model),
+	}
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	observe := func(i int, duration time.Duration) bool {
+		return Observe("api", "http://api", nil, start.Add(time.Duration(i)*time.Minute), duration)
+	}
+
+	if Observe("other", "http://other", nil, start, time.Second) {
+		t.Error("деградация у сервиса без настроек обнаружения")
+	}
+	for i := 0; i < 20; i++ {
+		if observe(i, 100*time.Millisecond) {
+			t.Fatalf("деградация во время обучения, проверка %d", i)
+		}
+	}
+	tests := []struct {
+		duration time.Duration
+		want     bool
+	}{
+		{time.Second, false},
+		{time.Second, false},
+		{time.Second, true}, // sustained проверок подряд с отклонением
+		{100 * time.Millisecond, true},
+		{100 * time.Millisecond, true},
+		{100 * time.Millisecond, false}, // sustained проверок подряд без отклонения
+	}
+	for i, test := range tests {
+		if got := observe(20+i, test.duration); got != test.want {
+			t.Errorf("проверка %d за %v: деградация %v, ожидается %v", i, test.duration, got, test.want)
+		}
+	}
+}
+
+func TestSavedDegraded(t *testing.T) {
+	dir := t.TempDir()
+	models := map[string]*model{"slow": {Degraded: true}, "fast": {}}
+	if err := helper.SaveState(dir, stateFileName, models); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir     string
+		service string
+		want    bool
+	}{
+		{dir, "slow", true},
+		{dir, "fast", false},
+		{dir, "missing", false},
+		{t.TempDir(), "slow", false}, // базовая линия ещё не сохранена
+		{"", "slow", false},          // каталог состояния не задан
+	}
+	for _, test := range tests {
+		got, err := SavedDegraded(test.dir, test.service)
+		if err != nil || got != test.want {
+			t.Errorf("SavedDegraded(%q, %s) = %v, %v, ожидается %v", test.dir, test.service, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"ws_monitoring/baseline"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+	"ws_monitoring/workmanager"
+)
+
+// Коды завершения программы
+const (
+	exitOK    = 0
+	exitError = 1 // ошибка конфигурации или запуска, неуспешная проверка
+	exitUsage = 2 // неверные аргументы командной строки
+)
+
+// Коды завершения команды check, совместимые с модулями Nagios/Icinga
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+const usageText = `Использование: ws_monitoring [команда] [параметры]
+
+Команды:
+  run       запуск мониторинга (по умолчанию)
+  validate  проверка конфигурационного файла
+  check     однократная проверка сервиса, совместимая с модулями Nagios/Icinga:
+            check [параметры] <имя сервиса | URL>
+  list      список сервисов из конфигурации
+  encrypt   шифрование пароля для конфигурации: значение читается из стандартного ввода
+  version   версия программы
+
+Параметры команды можно посмотреть так: ws_monitoring <команда> -h
+`
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор командной строки и выполнение команды. Возвращает код завершения программы.
+//----------------------------------------------------------------------------------------------------------------------
+func runCommand(args []string) int {
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "run":
+		if flags, code := parseFlags(command, args, nil); flags == nil {
+			return code
+		}
+		return runDaemon()
+	case "validate":
+		return commandValidate(args)
+	case "check":
+		return commandCheck(args)
+	case "list":
+		return commandList(args)
+	case "encrypt":
+		return commandEncrypt(args)
+	case "version":
+		fmt.Printf("ws_monitoring %s, собрано %s\n", version, buildtime)
+		return exitOK
+	case "help":
+		fmt.Print(usageText)
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "Неизвестная команда %q\n\n%s", command, usageText)
+	return exitUsage
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор параметров команды. Параметр --config есть у всех команд, остальные добавляет setup.
+// Если выполнение команды нужно прекратить (ошибка или справка), возвращается nil и код завершения.
+//----------------------------------------------------------------------------------------------------------------------
+func parseFlags(command string, args []string, setup func(flags *flag.FlagSet)) (*flag.FlagSet, int) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.StringVar(&helper.ConfigFileName, "config", helper.ConfigFileName, "путь к конфигурационному файлу или адрес http(s)://")
+	if setup != nil {
+		setup(flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	return flags, exitOK
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// validate: проверка конфигурационного файла без запуска мониторинга
+//----------------------------------------------------------------------------------------------------------------------
+func commandValidate(args []string) int {
+	if flags, code := parseFlags("validate", args, nil); flags == nil {
+		return code
+	}
+	cfg, err := helper.ReadConfig(helper.ConfigFileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintln(os.Stderr, "Предупреждение:", warning)
+	}
+	fmt.Printf("Конфигурация %s корректна: файлов %d, сервисов %d, сетевых проверок %d, контрольная сумма %s\n",
+		helper.ConfigFileName, len(cfg.Files), len(cfg.Services), len(cfg.Canaries), cfg.Checksum)
+	return exitOK
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// list: список сервисов из конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func commandList(args []string) int {
+	if flags, code := parseFlags("list", args, nil); flags == nil {
+		return code
+	}
+	cfg, err := helper.ReadConfig(helper.ConfigFileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	printServices(os.Stdout, cfg)
+	return exitOK
+}
+
+func printServices(out io.Writer, cfg *helper.Config) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ИМЯ\tВКЛЮЧЁН\tИНТЕРВАЛ\tАДРЕС\tЗАВИСИТ ОТ")
+	for _, service := range cfg.Services {
+		enabled := "нет"
+		if service.Enabled {
+			enabled = "да"
+		}
+		interval := service.CheckInterval.String()
+		if service.Schedule != "" {
+			interval = service.Schedule
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", service.Name, enabled, interval, service.Address,
+			strings.Join(service.DependsOn, ", "))
+	}
+	w.Flush()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// check: однократная проверка сервиса из конфигурации по имени или адресу либо произвольного URL.
+// Вывод и коды завершения совместимы с модулями Nagios/Icinga: одна строка состояния с данными
+// производительности, 0 - OK, 1 - WARNING, 2 - CRITICAL, 3 - UNKNOWN.
+//----------------------------------------------------------------------------------------------------------------------
+func commandCheck(args []string) int {
+	var login, password string
+	var warn, crit float64
+	var verbose bool
+	flags, code := parseFlags("check", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&login, "login", "", "имя пользователя для проверки произвольного URL")
+		flags.StringVar(&password, "password", "", "пароль для проверки произвольного URL")
+		flags.Float64Var(&warn, "warn", 0, "порог времени ответа для WARNING, в секундах")
+		flags.Float64Var(&crit, "crit", 0, "порог времени ответа для CRITICAL, в секундах")
+		flags.BoolVar(&verbose, "v", false, "вывод журнала проверки в stderr")
+	})
+	if flags == nil {
+		if code == exitUsage {
+			return nagiosUnknown
+		}
+		return code
+	}
+	if flags.NArg() != 1 {
+		fmt.Println("WS_MONITORING UNKNOWN - укажите имя сервиса или URL: ws_monitoring check [параметры] <имя сервиса | URL>")
+		return nagiosUnknown
+	}
+	target := flags.Arg(0)
+
+	service, cfg, err := findService(target)
+	if err != nil {
+		fmt.Printf("WS_MONITORING UNKNOWN - %s\n", firstLine(err.Error()))
+		return nagiosUnknown
+	}
+	if login != "" || password != "" {
+		service.Login, service.Password = login, helper.Secret(password)
+		helper.AddSecretValue(password)
+	}
+	if warn > 0 {
+		service.WarnLatency = helper.Duration(warn * float64(time.Second))
+	}
+	if crit > 0 {
+		service.CritLatency = helper.Duration(crit * float64(time.Second))
+	}
+
+	out := ioutil.Discard
+	level := "ERROR"
+	if verbose {
+		out, level = os.Stderr, "DEBUG"
+	}
+	if err := log.InitConsoleLogger(out, level); err != nil {
+		fmt.Printf("WS_MONITORING UNKNOWN - %v\n", err)
+		return nagiosUnknown
+	}
+	result := workmanager.CheckOnce(service)
+	if cfg != nil && result.Error == "" && result.Severity != workmanager.SeverityCritical {
+		// Базовую линию ведёт работающий мониторинг, используется её сохранённое состояние
+		if result.Degraded, err = baseline.SavedDegraded(cfg.StateDir, service.Name); err != nil {
+			log.Errorf("check, ошибка чтения базовой линии: %v", err)
+		}
+	}
+	fmt.Println(pluginOutput(service, result))
+	switch result.Severity {
+	case workmanager.SeverityOK:
+		return nagiosOK
+	case workmanager.SeverityWarning:
+		return nagiosWarning
+	}
+	return nagiosCritical
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Строка состояния модуля Nagios: СЛУЖБА СОСТОЯНИЕ - текст | данные производительности
+//----------------------------------------------------------------------------------------------------------------------
+func pluginOutput(service helper.Service, result *workmanager.CheckResult) string {
+	text := fmt.Sprintf("%s: код %d, время %.3f с", service.Name, result.StatusCode, result.CheckDuration.Seconds())
+	if result.Reason != "" {
+		text += ", " + result.Reason
+	}
+	if result.Degraded {
+		text += ", " + workmanager.WorkerStateDegraded + ": время ответа выше базовой линии"
+	}
+	warn, crit := threshold(service.WarnLatency.Seconds()), threshold(service.CritLatency.Seconds())
+	perfdata := fmt.Sprintf("time=%.3fs;%s;%s;0", result.CheckDuration.Seconds(), warn, crit)
+	for _, backend := range result.Backends {
+		perfdata += fmt.Sprintf(" 'time_%s'=%.3fs;%s;%s;0", backend.Backend, backend.CheckDuration.Seconds(), warn, crit)
+	}
+	// Символ | отделяет данные производительности и не может встречаться в тексте
+	text = strings.Replace(text, "|", "/", -1)
+	return helper.RedactSecrets(fmt.Sprintf("WS_MONITORING %s - %s | %s", result.Severity, text, perfdata))
+}
+
+// Порог в данных производительности, пусто если не задан
+func threshold(value float64) string {
+	if value <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func firstLine(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Поиск сервиса в конфигурации по имени или адресу. Если сервис не найден, а указан URL,
+// проверяется этот URL с настройками по умолчанию. Конфигурация возвращается для найденного в ней сервиса.
+//----------------------------------------------------------------------------------------------------------------------
+func findService(target string) (helper.Service, *helper.Config, error) {
+	cfg, err := helper.ReadConfig(helper.ConfigFileName)
+	if err == nil {
+		for _, service := range cfg.Services {
+			if service.Name == target || service.Address == target {
+				return service, cfg, nil
+			}
+		}
+	}
+	if u, parseErr := url.Parse(target); parseErr == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return helper.Service{Name: target, Address: target, Enabled: true}, nil, nil
+	}
+	if err != nil {
+		return helper.Service{}, nil, err
+	}
+	return helper.Service{}, nil, fmt.Errorf("Сервис %q не найден в %s", target, helper.ConfigFileName)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// encrypt: шифрование пароля в значение enc: для конфигурации. Пароль читается из стандартного ввода,
+// чтобы он не попал в историю команд.
+//----------------------------------------------------------------------------------------------------------------------
+func commandEncrypt(args []string) int {
+	var keyFile string
+	var generate bool
+	flags, code := parseFlags("encrypt", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&keyFile, "key-file", "", "файл ключа, по умолчанию encryption_key_file из конфигурации или "+
+			helper.EncryptionKeyEnv)
+		flags.BoolVar(&generate, "generate-key", false, "создать новый файл ключа")
+	})
+	if flags == nil {
+		return code
+	}
+	if keyFile == "" {
+		cfg, _ := helper.ReadConfig(helper.ConfigFileName)
+		keyFile = helper.EncryptionKeyFile(cfg)
+	}
+	if generate {
+		if keyFile == "" {
+			fmt.Fprintln(os.Stderr, "Укажите файл ключа: --key-file")
+			return exitUsage
+		}
+		if err := helper.GenerateEncryptionKey(keyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Не удалось создать файл ключа: %v\n", err)
+			return exitError
+		}
+		fmt.Fprintf(os.Stderr, "Создан файл ключа %s. Укажите его в encryption_key_file.\n", keyFile)
+		return exitOK
+	}
+	key, err := helper.LoadEncryptionKey(keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	fmt.Fprint(os.Stderr, "Пароль: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	value := strings.TrimRight(line, "\r\n")
+	if value == "" {
+		fmt.Fprintln(os.Stderr, "Пустой пароль")
+		return exitError
+	}
+	encrypted, err := helper.EncryptSecret(key, value)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fmt.Println(encrypted)
+	return exitOK
+}
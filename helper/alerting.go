@@ -0,0 +1,92 @@
+package helper
+
+import "regexp"
+
+// AlertingConfig - настройки оповещений: список получателей и дерево маршрутизации
+type AlertingConfig struct {
+	Receivers          []Receiver         `yaml:"receivers"`
+	Route              *Route             `yaml:"route"`
+	EscalationPolicies []EscalationPolicy `yaml:"escalation_policies"`
+	AckSecret          Secret             `yaml:"ack_secret"` // ключ для подписи ссылок подтверждения
+}
+
+// Route - узел дерева маршрутизации оповещений.
+// Корневой узел соответствует любому оповещению, дочерние узлы уточняют условия.
+type Route struct {
+	Receiver       string            `yaml:"receiver"`    // имя получателя, по умолчанию наследуется от родителя
+	Match          map[string]string `yaml:"match"`       // точное совпадение меток: service, severity, alertname и теги сервиса
+	MatchRE        map[string]string `yaml:"match_re"`    // совпадение меток по регулярному выражению
+	Severity       []string          `yaml:"severity"`    // допустимые уровни важности
+	ActiveTime     []TimeRange       `yaml:"active_time"` // время суток, в которое действует маршрут
+	Continue       bool              `yaml:"continue"`    // продолжить поиск среди соседних маршрутов после совпадения
+	GroupBy        []string          `yaml:"group_by"`    // метки для группировки оповещений, "..." - все метки
+	GroupWait      Duration          `yaml:"group_wait"`
+	GroupInterval  Duration          `yaml:"group_interval"`
+	RepeatInterval Duration          `yaml:"repeat_interval"`
+	Escalation     string            `yaml:"escalation"` // имя политики эскалации
+	Routes         []*Route          `yaml:"routes"`
+
+	MatchRegexp map[string]*regexp.Regexp `yaml:"-"` // скомпилированные match_re, заполняются при проверке конфигурации
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Компиляция выражения match_re: выражение должно совпадать со значением метки целиком
+//----------------------------------------------------------------------------------------------------------------------
+func CompileMatchRE(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// TimeRange - интервал времени суток по дням недели
+type TimeRange struct {
+	Weekdays []string `yaml:"weekdays"` // mon, tue, ... или диапазон mon-fri, пустой список - все дни
+	Start    string   `yaml:"start"`    // ЧЧ:ММ
+	End      string   `yaml:"end"`      // ЧЧ:ММ, может быть меньше Start - интервал через полночь
+}
+
+// Receiver - получатель оповещений
+type Receiver struct {
+	Name     string          `yaml:"name"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Emails   []EmailConfig   `yaml:"emails"`
+}
+
+// WebhookConfig - отправка оповещений POST-запросом в формате JSON
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+}
+
+// EmailConfig - отправка оповещений по электронной почте
+type EmailConfig struct {
+	To        []string `yaml:"to"`
+	From      string   `yaml:"from"`
+	SmartHost string   `yaml:"smarthost"` // адрес SMTP-сервера в формате host:port
+	Username  string   `yaml:"username"`
+	Password  Secret   `yaml:"password"`
+}
+
+// EscalationPolicy - порядок эскалации неподтверждённого оповещения
+type EscalationPolicy struct {
+	Name  string           `yaml:"name"`
+	Tiers []EscalationTier `yaml:"tiers"`
+}
+
+// EscalationTier - уровень эскалации
+type EscalationTier struct {
+	Delay    Duration `yaml:"delay"` // от начала проблемы
+	Receiver string   `yaml:"receiver"`
+}
+
+// MaintenanceWindow - окно обслуживания. Проверки выполняются, но оповещения не отправляются,
+// а результаты отмечаются состоянием maintenance.
+// Окно задаётся одним из способов: разовое start/end, расписание schedule с длительностью duration,
+// либо интервалы времени суток time_ranges.
+type MaintenanceWindow struct {
+	Name       string            `yaml:"name"`
+	Services   []string          `yaml:"services"` // имена сервисов
+	Tags       map[string]string `yaml:"tags"`     // метки сервисов, должны совпасть все
+	Start      string            `yaml:"start"`    // 2006-01-02 15:04 или RFC3339
+	End        string            `yaml:"end"`
+	Schedule   string            `yaml:"schedule"` // расписание начала окна в формате cron
+	Duration   Duration          `yaml:"duration"`
+	TimeRanges []TimeRange       `yaml:"time_ranges"`
+}
@@ -1,73 +1,225 @@
 package helper
 
 import (
+	"bytes"
 	"errors"
-	"io/ioutil"
-	"os"
+	"io"
+	"sort"
 	"time"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
-const ConfigFileName = "ws_monitoring.yaml"
+const defaultReloadConfigInterval = Duration(60 * time.Second)
+
+// Число одновременных проверок по умолчанию
+const defaultMaxCheckThreads = 64
+
+// Число пропущенных контрольных сигналов, после которого рабочий поток считается зависшим
+const defaultMissedHeartbeats = 3
+
+// Путь к конфигурационному файлу, задаётся параметром --config
+var ConfigFileName = "ws_monitoring.yaml"
 
 var (
-	configModTime  int64
+	configChecksum string // контрольная сумма последнего прочитанного содержимого файлов
 	ErrNotModified = errors.New("Not modified")
 )
 
 // Service - структура настроек для web-сервиса, который будет мониториться
 type Service struct {
-	Address       string        `yaml:"address"`
-	Login         string        `yaml:"login"`
-	Password      string        `yaml:"password"`
-	Enabled       bool          `yaml:"enabled"`
-	CheckInterval time.Duration `yaml:"check_interval"`
+	Name          string              `yaml:"name"` // по умолчанию совпадает с адресом
+	Address       string              `yaml:"address"`
+	Login         string              `yaml:"login"`
+	Password      Secret              `yaml:"password"`
+	Enabled       bool                `yaml:"enabled"`
+	CheckInterval Duration            `yaml:"check_interval"` // число секунд или строка вида 30s, 1m
+	Schedule      string              `yaml:"schedule"`       // расписание проверок в формате cron вместо интервала
+	Tags          map[string]string   `yaml:"tags"`           // произвольные метки для маршрутизации оповещений
+	DependsOn     []string            `yaml:"depends_on"`     // имена сервисов или сетевых проверок, от которых зависит сервис
+	SLO           *SLOConfig          `yaml:"slo"`
+	Anomaly       *AnomalyConfig      `yaml:"anomaly"`
+	WarnLatency   Duration            `yaml:"warn_latency"` // порог времени ответа для WARNING
+	CritLatency   Duration            `yaml:"crit_latency"` // порог времени ответа для CRITICAL
+	StatusCodes   map[string]string   `yaml:"status_codes"` // уровень по коду ответа: "404" или класс "4xx" -> ok/warning/critical
+	Template      string              `yaml:"template"`     // имя шаблона из templates, от которого наследуются параметры
+	Matrix        map[string][]string `yaml:"matrix"`       // значения переменных {имя}: сервис на каждое сочетание
+	PerBackend    bool                `yaml:"per_backend"`  // проверять каждый адрес A/AAAA имени узла отдельно
+	Priority      int                 `yaml:"priority"`     // при нехватке потоков проверки с большим приоритетом запускаются раньше
+	Jitter        Duration            `yaml:"jitter"`       // предельная случайная задержка каждой проверки
+}
+
+// SLOConfig - целевые показатели уровня обслуживания сервиса
+type SLOConfig struct {
+	Availability     float64  `yaml:"availability"`      // цель доступности, %, например 99.9
+	Latency          Duration `yaml:"latency"`           // порог времени ответа
+	LatencyObjective float64  `yaml:"latency_objective"` // доля проверок быстрее порога, %
+	WindowDays       int      `yaml:"window_days"`       // длина скользящего окна, в днях, по умолчанию 30
+}
+
+// AnomalyConfig - обнаружение аномального времени ответа относительно выученной базовой линии
+type AnomalyConfig struct {
+	Threshold  float64 `yaml:"threshold"`   // отклонение в стандартных отклонениях, по умолчанию 3
+	Sustained  int     `yaml:"sustained"`   // число проверок подряд с отклонением, по умолчанию 5
+	Alpha      float64 `yaml:"alpha"`       // коэффициент сглаживания EWMA, по умолчанию 0.05
+	MinSamples int     `yaml:"min_samples"` // число проверок для обучения перед началом контроля, по умолчанию 100
+}
+
+// Canary - сетевая проверка (TCP-подключение), от которой могут зависеть сервисы
+type Canary struct {
+	Name          string   `yaml:"name"`
+	Address       string   `yaml:"address"` // host:port
+	CheckInterval Duration `yaml:"check_interval"`
 }
 
 // Config - структура для считывания конфигурационного файла
 type Config struct {
-	ReloadConfigInterval int       `yaml:"reload_config_interval"`
-	LogLevel             string    `yaml:"log_level"`
-	LogFilename          string    `yaml:"log_filename"`
-	DataCollectorURL     string    `yaml:"data_collector_url"`
-	Services             []Service `yaml:"services"`
+	ReloadConfigInterval Duration            `yaml:"reload_config_interval"` // период проверки изменений файлов
+	LogLevel             string              `yaml:"log_level"`
+	MaxCheckThreads      int                 `yaml:"max_check_threads"`          // число одновременных проверок
+	MissedHeartbeats     int                 `yaml:"watchdog_missed_heartbeats"` // сторожевой таймер: пропущенных проверок до STALLED
+	LogFilename          string              `yaml:"log_filename"`
+	DataCollectorURL     string              `yaml:"data_collector_url"`
+	Services             []Service           `yaml:"services"`
+	Defaults             *Service            `yaml:"defaults"`  // параметры по умолчанию для всех сервисов
+	Templates            map[string]Service  `yaml:"templates"` // именованные шаблоны сервисов
+	Discovery            []DiscoveryConfig   `yaml:"discovery"` // источники обнаружения сервисов
+	Alerting             AlertingConfig      `yaml:"alerting"`
+	Maintenance          []MaintenanceWindow `yaml:"maintenance"`
+	Canaries             []Canary            `yaml:"canaries"`
+	HTTPListen           string              `yaml:"http_listen"`         // адрес HTTP API, например :8080
+	APIToken             Secret              `yaml:"api_token"`           // токен для изменяющих запросов к HTTP API
+	ExternalURL          string              `yaml:"external_url"`        // внешний адрес HTTP API для ссылок в оповещениях
+	StateDir             string              `yaml:"state_dir"`           // каталог для сохранения состояния между перезапусками
+	Vault                *VaultConfig        `yaml:"vault"`               // источник секретов для ссылок vault:
+	EncryptionKeyFile    string              `yaml:"encryption_key_file"` // ключ для значений enc:, вне каталога конфигурации
+	Include              []string            `yaml:"include"`             // шаблоны подключаемых файлов, по умолчанию conf.d/*.yaml
+	Checksum             string              `yaml:"-"`                   // SHA-256 содержимого всех файлов
+	Files                []string            `yaml:"-"`                   // прочитанные файлы: основной и подключённые
+	Warnings             []string            `yaml:"-"`                   // источники по HTTP, вместо которых использованы копии
 }
 
 //----------------------------------------------------------------------------------------------------------------------
-// Загрузка конфигурации из указанного файла
+// Загрузка конфигурации из указанного файла и подключаемых файлов
 //----------------------------------------------------------------------------------------------------------------------
 func ReadConfig(ConfigName string) (x *Config, err error) {
-	var file []byte
-	if file, err = ioutil.ReadFile(ConfigName); err != nil {
+	var sources []configSource
+	if sources, err = readConfigSources(ConfigName); err != nil {
 		return nil, err
 	}
-	x = new(Config)
-	if err = yaml.Unmarshal(file, x); err != nil {
-		return nil, err
+	if x, err = parseConfig(ConfigName, sources); err == nil {
+		commitRemote(sources)
+		commitSecrets()
+	} else {
+		discardSecrets()
 	}
+	return x, err
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор и проверка содержимого конфигурационных файлов. Первым идёт основной файл.
+//----------------------------------------------------------------------------------------------------------------------
+func parseConfig(ConfigName string, sources []configSource) (x *Config, err error) {
+	file := sources[0].Data
+	// Дерево узлов нужно для поиска номеров строк при проверке
+	var root yaml.Node
+	if err = yaml.Unmarshal(file, &root); err != nil {
+		return nil, &ConfigError{File: ConfigName, Problems: yamlProblems(err)}
+	}
+
+	// Неизвестные параметры считаются ошибкой: опечатка в имени не должна молча отключать настройку.
+	// Ошибки типов не прерывают проверку, чтобы сообщить обо всех проблемах сразу.
+	var problems []Problem
+	x = &Config{Files: []string{ConfigName}}
+	if sources[0].Warning != "" {
+		x.Warnings = append(x.Warnings, sources[0].Warning)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(file))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(x); err != nil && err != io.EOF {
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return nil, &ConfigError{File: ConfigName, Problems: yamlProblems(err)}
+		}
+		problems = yamlProblems(err)
+	}
+	tree := &configTree{root: &root}
+	problems = append(problems, x.include(tree, sources[1:])...)
+	x.discover(tree)
+	x.Checksum = configSum(sources)
+	problems = append(problems, x.applyTemplates(tree)...)
 	if x.LogLevel == "" {
 		x.LogLevel = "Debug"
 	}
+	if x.ReloadConfigInterval == 0 {
+		x.ReloadConfigInterval = defaultReloadConfigInterval
+	}
+	if x.MaxCheckThreads == 0 {
+		x.MaxCheckThreads = defaultMaxCheckThreads
+	}
+	if x.MissedHeartbeats == 0 {
+		x.MissedHeartbeats = defaultMissedHeartbeats
+	}
+	for i := range x.Services {
+		if x.Services[i].Name == "" {
+			x.Services[i].Name = x.Services[i].Address
+		}
+	}
+
+	problems = append(problems, x.resolveSecrets(tree)...)
+	problems = append(problems, x.validate(tree)...)
+	if len(problems) > 0 {
+		// Сначала проблемы основного файла, затем подключаемых, внутри файла по строкам
+		sort.SliceStable(problems, func(i, j int) bool {
+			if problems[i].File != problems[j].File {
+				return problems[i].File < problems[j].File
+			}
+			return problems[i].Line < problems[j].Line
+		})
+		return nil, &ConfigError{File: ConfigName, Problems: problems}
+	}
 	return x, nil
 }
 
 //----------------------------------------------------------------------------------------------------------------------
-// Проверка времени изменения конфигурационного файла и перезагрузка его, если он изменился
-// Возврат errNotModified если изменений нет
+// Перезагрузка конфигурации, если содержимое основного или подключаемых файлов либо обнаруженные сервисы
+// изменились. Возврат ErrNotModified если изменений нет. При force файлы загружаются независимо от изменений.
+// Некорректное содержимое повторно не загружается, пока файлы снова не изменятся.
 //----------------------------------------------------------------------------------------------------------------------
-func ReloadConfig(configName string) (cfg *Config, err error) {
-	info, err := os.Stat(configName)
+func ReloadConfig(configName string, force bool) (cfg *Config, err error) {
+	sources, err := readConfigSources(configName)
 	if err != nil {
 		return nil, err
 	}
-	if configModTime != info.ModTime().UnixNano() {
-		configModTime = info.ModTime().UnixNano()
-		cfg, err = ReadConfig(configName)
-		if err != nil {
-			return nil, err
-		}
-		return cfg, nil
+	// Опрос источников обнаружения из последней загруженной конфигурации: parseConfig использует его результаты
+	discovery.refresh()
+	sum := configSum(sources)
+	if !force && sum == configChecksum {
+		return nil, ErrNotModified
 	}
-	return nil, ErrNotModified
+	configChecksum = sum
+	if cfg, err = parseConfig(configName, sources); err == nil {
+		// Новая конфигурация могла добавить источники обнаружения
+		configChecksum = cfg.Checksum
+		commitRemote(sources)
+		commitSecrets()
+	} else {
+		discardSecrets()
+	}
+	return cfg, err
+}
+
+// Контрольная сумма файлов и результатов обнаружения сервисов
+func configSum(sources []configSource) string {
+	sum := sourcesChecksum(sources)
+	if digest := discovery.digest(); digest != "" {
+		return checksumOf(sum + digest)
+	}
+	return sum
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Контрольная сумма последнего прочитанного содержимого конфигурационных файлов
+//----------------------------------------------------------------------------------------------------------------------
+func ConfigChecksum() string {
+	return configChecksum
 }
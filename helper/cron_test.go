@@ -0,0 +1,79 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string // начало сообщения об ошибке, пусто - расписание корректно
+	}{
+		{"*/5 * * * *", ""},
+		{"0 8-18/2 * * mon-fri", ""},
+		{"30 2 1,15 jan,jul 0", ""},
+		{"0 0 * * 7", ""},
+		{"* * * *", "Расписание \"* * * *\": ожидается 5 полей, получено 4"},
+		{"* * * * * *", "Расписание \"* * * * * *\": ожидается 5 полей"},
+		{"60 * * * *", "Поле минута: недопустимое значение \"60\""},
+		{"* 24 * * *", "Поле час: недопустимое значение \"24\""},
+		{"* * 0 * *", "Поле день месяца: недопустимое значение \"0\""},
+		{"* * * 13 *", "Поле месяц: недопустимое значение \"13\""},
+		{"* * * * 8", "Поле день недели: недопустимое значение \"8\""},
+		{"* * * foo *", "Поле месяц: недопустимое значение \"foo\""},
+		{"*/0 * * * *", "Поле минута: неверный шаг"},
+		{"*/x * * * *", "Поле минута: неверный шаг"},
+		{"30-10 * * * *", "Поле минута: неверный диапазон"},
+	}
+	for _, test := range tests {
+		_, err := ParseSchedule(test.spec)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("ParseSchedule(%q): %v", test.spec, err)
+		case test.want != "" && (err == nil || !strings.HasPrefix(err.Error(), test.want)):
+			t.Errorf("ParseSchedule(%q): ошибка %v, ожидается %q", test.spec, err, test.want)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// 4 марта 2024 года - понедельник
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/5 * * * *", at(3, 4, 10, 2), at(3, 4, 10, 5)},
+		{"*/5 * * * *", at(3, 4, 10, 5), at(3, 4, 10, 10)}, // строго после from
+		{"*/5 * * * *", at(3, 4, 10, 5).Add(30 * time.Second), at(3, 4, 10, 10)},
+		{"0 * * * *", at(3, 4, 23, 30), at(3, 5, 0, 0)},
+		{"30 9 * * *", at(3, 4, 10, 0), at(3, 5, 9, 30)},
+		{"0 8-18/2 * * mon-fri", at(3, 8, 18, 0), at(3, 11, 8, 0)}, // из пятницы в понедельник
+		{"0 0 * * 7", at(3, 4, 0, 0), at(3, 10, 0, 0)},             // 7 - воскресенье
+		{"0 0 * * sun", at(3, 4, 0, 0), at(3, 10, 0, 0)},
+		{"0 0 1 * *", at(3, 4, 0, 0), at(4, 1, 0, 0)},
+		{"0 0 29 feb *", at(3, 4, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", at(3, 4, 0, 0), at(3, 8, 0, 0)}, // день месяца или день недели
+		{"0 0 1 jan *", at(12, 31, 23, 59), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", at(3, 4, 0, 0), time.Time{}}, // не срабатывает никогда
+	}
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", test.spec, err)
+		}
+		got := s.Next(test.from)
+		if !got.Equal(test.want) {
+			t.Errorf("%q после %s: %s, ожидается %s", test.spec, test.from.Format(time.RFC3339),
+				got.Format(time.RFC3339), test.want.Format(time.RFC3339))
+		}
+		if !got.IsZero() && !s.Matches(got) {
+			t.Errorf("%q: Matches(%s) = false для времени срабатывания", test.spec, got.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Типы источников обнаружения сервисов
+const (
+	DiscoveryFile = "file" // текстовый или CSV-файл со списком адресов
+	DiscoveryDNS  = "dns"  // записи SRV или A/AAAA
+	DiscoveryHTTP = "http" // JSON-список сервисов по HTTP
+)
+
+const (
+	defaultDiscoveryRefresh = Duration(5 * time.Minute)
+	discoveryTimeout        = 10 * time.Second // общее время опроса источников за период перезагрузки
+	discoverySourceTag      = "source"         // метка с именем источника у обнаруженных сервисов
+)
+
+var discoveryTypes = []string{DiscoveryFile, DiscoveryDNS, DiscoveryHTTP}
+
+// DiscoveryConfig - источник обнаружения сервисов. Обнаруженные сервисы добавляются к описанным
+// в конфигурации, получают метку source с именем источника и наследуют параметры шаблона template.
+type DiscoveryConfig struct {
+	Name            string            `yaml:"name"`
+	Type            string            `yaml:"type"`             // file, dns или http
+	File            string            `yaml:"file"`             // file: строки "адрес" или "имя,адрес", путь относительно конфигурации
+	SRV             string            `yaml:"srv"`              // dns: запись SRV, например _http._tcp.example.com
+	Host            string            `yaml:"host"`             // dns: имя для поиска адресов A/AAAA, если не указана srv
+	Port            int               `yaml:"port"`             // dns: порт для host
+	Scheme          string            `yaml:"scheme"`           // dns: http или https, по умолчанию http
+	Path            string            `yaml:"path"`             // dns: путь в адресе сервиса
+	URL             string            `yaml:"url"`              // http: адрес JSON-списка сервисов
+	Template        string            `yaml:"template"`         // шаблон параметров обнаруженных сервисов
+	CheckInterval   Duration          `yaml:"check_interval"`   // интервал проверки, если не задан шаблоном или defaults
+	Tags            map[string]string `yaml:"tags"`             // дополнительные метки обнаруженных сервисов
+	RefreshInterval Duration          `yaml:"refresh_interval"` // период опроса dns и http, по умолчанию 5m
+}
+
+// Тип - результат последнего опроса источника
+type discoveryResult struct {
+	config    DiscoveryConfig
+	services  []Service
+	fetchedAt time.Time // время последнего успешного опроса
+	checkedAt time.Time // время последней попытки
+	err       error     // ошибка последней попытки, сервисы остаются от последнего успешного опроса
+}
+
+// discoveryState - синглтон с результатами опроса источников
+type discoveryState struct {
+	mutex     sync.Mutex
+	providers []DiscoveryConfig
+	results   map[string]*discoveryResult
+}
+
+var discovery = &discoveryState{results: make(map[string]*discoveryResult)}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Опрос источников последней конфигурации, для которых истёк период refresh_interval. Файлы перечитываются
+// при каждом вызове. Вызывается один раз за период перезагрузки конфигурации, опрос ограничен discoveryTimeout.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *discoveryState) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.poll(ctx, d.providers, false)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Источники загружаемой конфигурации. Опрашиваются только новые источники и источники с изменёнными настройками,
+// остальные используют результаты последнего опроса в refresh.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *discoveryState) update(providers []DiscoveryConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.providers = providers
+	d.poll(ctx, providers, true)
+}
+
+// Опрос источников: при added - только ещё не опрошенных, иначе - с истёкшим периодом refresh_interval
+func (d *discoveryState) poll(ctx context.Context, providers []DiscoveryConfig, added bool) {
+	now := time.Now()
+	active := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		active[provider.Name] = true
+		result := d.results[provider.Name]
+		if result == nil || !equalDiscovery(result.config, provider) {
+			result = &discoveryResult{config: provider}
+			d.results[provider.Name] = result
+		}
+		interval := provider.RefreshInterval
+		if interval <= 0 {
+			interval = defaultDiscoveryRefresh
+		}
+		switch {
+		case result.checkedAt.IsZero():
+		case added:
+			continue
+		case provider.Type != DiscoveryFile && now.Sub(result.checkedAt) < interval.Duration():
+			continue
+		}
+		result.checkedAt = now
+		services, err := provider.discover(ctx)
+		if result.err = err; err == nil {
+			result.services, result.fetchedAt = services, now
+		}
+	}
+	for name := range d.results {
+		if !active[name] {
+			delete(d.results, name)
+		}
+	}
+}
+
+// Результат опроса источника и предупреждение, если последний опрос неуспешен
+func (d *discoveryState) result(name string) ([]Service, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	result := d.results[name]
+	if result == nil {
+		return nil, ""
+	}
+	if result.err == nil {
+		return result.services, ""
+	}
+	if result.fetchedAt.IsZero() {
+		return nil, fmt.Sprintf("discovery %s: %v", name, result.err)
+	}
+	return result.services, fmt.Sprintf("discovery %s: %v, используются сервисы, обнаруженные %s", name, result.err,
+		result.fetchedAt.Format("2006-01-02 15:04:05"))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Контрольная сумма результатов опроса: её изменение приводит к перезагрузке конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func (d *discoveryState) digest() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.results) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(d.results))
+	for name := range d.results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00", name)
+		for _, service := range d.results[name].services {
+			data, _ := json.Marshal(service.Tags)
+			fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", service.Name, service.Address, data)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Файлы источников последней конфигурации для отслеживания изменений
+func (d *discoveryState) files() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var files []string
+	for _, provider := range d.providers {
+		if provider.Type == DiscoveryFile && provider.File != "" {
+			files = append(files, absPath(provider.filePath()))
+		}
+	}
+	return files
+}
+
+func equalDiscovery(a DiscoveryConfig, b DiscoveryConfig) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Добавление обнаруженных сервисов к описанным в конфигурации. Сервис с уже занятым именем пропускается:
+// описание в конфигурации важнее обнаруженного.
+//----------------------------------------------------------------------------------------------------------------------
+func (c *Config) discover(tree *configTree) {
+	discovery.update(c.Discovery)
+	names := make(map[string]bool, len(c.Services))
+	for _, service := range c.Services {
+		if service.Name != "" {
+			names[service.Name] = true
+		} else {
+			names[service.Address] = true
+		}
+	}
+	for j, provider := range c.Discovery {
+		services, warning := discovery.result(provider.Name)
+		if warning != "" {
+			c.Warnings = append(c.Warnings, warning)
+		}
+		o := origin{root: tree.root, prefix: path{"discovery", j}, keys: provider.keys()}
+		for _, service := range services {
+			if names[service.Name] {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("discovery %s: сервис %q уже описан, обнаруженный пропущен",
+					provider.Name, service.Name))
+				continue
+			}
+			names[service.Name] = true
+			c.Services = append(c.Services, service)
+			tree.origins["services"] = append(tree.origins["services"], o)
+		}
+	}
+}
+
+// Параметры, заданные у обнаруженного сервиса: остальные наследуются от шаблона и defaults
+func (p DiscoveryConfig) keys() map[string]bool {
+	keys := map[string]bool{"name": true, "address": true, "enabled": true, "tags": true}
+	if p.CheckInterval > 0 {
+		keys["check_interval"] = true
+	}
+	return keys
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Опрос источника
+//----------------------------------------------------------------------------------------------------------------------
+func (p DiscoveryConfig) discover(ctx context.Context) ([]Service, error) {
+	var entries []discoveredEntry
+	var err error
+	switch p.Type {
+	case DiscoveryFile:
+		entries, err = p.discoverFile()
+	case DiscoveryDNS:
+		entries, err = p.discoverDNS(ctx)
+	case DiscoveryHTTP:
+		entries, err = p.discoverHTTP(ctx)
+	default:
+		err = fmt.Errorf("неизвестный тип источника %q", p.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]Service, 0, len(entries))
+	for _, entry := range entries {
+		// Неверные адреса из внешнего источника пропускаются, чтобы не отклонять всю конфигурацию
+		if u, err := url.Parse(entry.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		if entry.Name == "" {
+			entry.Name = entry.Address
+		}
+		tags := map[string]string{discoverySourceTag: p.Name}
+		for _, m := range []map[string]string{p.Tags, entry.Tags} {
+			for k, v := range m {
+				tags[k] = v
+			}
+		}
+		services = append(services, Service{
+			Name:          entry.Name,
+			Address:       entry.Address,
+			Enabled:       true,
+			CheckInterval: p.CheckInterval,
+			Tags:          tags,
+			Template:      p.Template,
+		})
+	}
+	sort.SliceStable(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// Тип - запись источника обнаружения
+type discoveredEntry struct {
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Tags    map[string]string `json:"tags"`
+}
+
+func (p DiscoveryConfig) filePath() string {
+	if filepath.IsAbs(p.File) {
+		return p.File
+	}
+	return filepath.Join(configDir(), p.File)
+}
+
+// Файл со строками "адрес" или "имя,адрес". Пустые строки и строки с # пропускаются.
+func (p DiscoveryConfig) discoverFile() ([]discoveredEntry, error) {
+	data, err := ioutil.ReadFile(p.filePath())
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var entries []discoveredEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch len(record) {
+		case 1:
+			entries = append(entries, discoveredEntry{Address: strings.TrimSpace(record[0])})
+		default:
+			entries = append(entries, discoveredEntry{Name: strings.TrimSpace(record[0]), Address: strings.TrimSpace(record[1])})
+		}
+	}
+	return entries, nil
+}
+
+// Адрес сервиса на каждый узел из записи SRV или на каждый адрес имени host
+func (p DiscoveryConfig) discoverDNS(ctx context.Context) ([]discoveredEntry, error) {
+	var targets []string
+	if p.SRV != "" {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", p.SRV)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			targets = append(targets, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+		}
+	} else {
+		addresses, err := net.DefaultResolver.LookupHost(ctx, p.Host)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			if p.Port > 0 {
+				address = net.JoinHostPort(address, strconv.Itoa(p.Port))
+			} else if strings.Contains(address, ":") {
+				address = "[" + address + "]"
+			}
+			targets = append(targets, address)
+		}
+	}
+	scheme := p.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	entries := make([]discoveredEntry, 0, len(targets))
+	for _, target := range targets {
+		address := (&url.URL{Scheme: scheme, Host: target, Path: p.Path}).String()
+		entries = append(entries, discoveredEntry{
+			Name:    p.Name + "/" + target,
+			Address: address,
+			Tags:    map[string]string{"backend": target},
+		})
+	}
+	return entries, nil
+}
+
+// JSON-массив объектов {"name", "address", "tags"} или строк с адресами
+func (p DiscoveryConfig) discoverHTTP(ctx context.Context) ([]discoveredEntry, error) {
+	request, err := http.NewRequest(http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := remoteClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ответ %s", response.Status)
+	}
+	var items []json.RawMessage
+	if err = json.NewDecoder(io.LimitReader(response.Body, remoteMaxSize)).Decode(&items); err != nil {
+		return nil, fmt.Errorf("ожидается JSON-массив сервисов: %v", err)
+	}
+	entries := make([]discoveredEntry, 0, len(items))
+	for _, item := range items {
+		var entry discoveredEntry
+		if err = json.Unmarshal(item, &entry.Address); err != nil {
+			if err = json.Unmarshal(item, &entry); err != nil {
+				return nil, fmt.Errorf("неверный элемент списка %s: %v", item, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
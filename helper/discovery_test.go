@@ -0,0 +1,52 @@
+package helper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Источник опрашивается один раз за период перезагрузки: разбор конфигурации использует результаты refresh
+func TestDiscoveryPolledOncePerReload(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `["http://api-1/", "http://api-2/"]`)
+	}))
+	defer server.Close()
+
+	d := &discoveryState{results: make(map[string]*discoveryResult)}
+	provider := DiscoveryConfig{Name: "api", Type: DiscoveryHTTP, URL: server.URL, RefreshInterval: Duration(time.Hour)}
+	tests := []struct {
+		name string
+		poll func()
+		want int32
+	}{
+		{"новый источник опрашивается при разборе", func() { d.update([]DiscoveryConfig{provider}) }, 1},
+		{"повторный разбор использует результаты", func() { d.update([]DiscoveryConfig{provider}) }, 1},
+		{"период refresh_interval не истёк", d.refresh, 1},
+		{"разбор после refresh не опрашивает", func() { d.update([]DiscoveryConfig{provider}) }, 1},
+		{"изменены настройки источника", func() {
+			provider.RefreshInterval = Duration(time.Nanosecond)
+			d.update([]DiscoveryConfig{provider})
+		}, 2},
+		{"период refresh_interval истёк", d.refresh, 3},
+	}
+	for _, test := range tests {
+		test.poll()
+		if got := atomic.LoadInt32(&requests); got != test.want {
+			t.Errorf("%s: запросов %d, ожидается %d", test.name, got, test.want)
+		}
+	}
+	if services, warning := d.result("api"); len(services) != 2 || warning != "" {
+		t.Errorf("обнаружено %d сервисов, предупреждение %q, ожидается 2 без предупреждения", len(services), warning)
+	}
+
+	d.update(nil)
+	if services, _ := d.result("api"); services != nil {
+		t.Errorf("результаты удалённого источника сохранены: %v", services)
+	}
+}
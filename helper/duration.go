@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration - длительность в конфигурации. Задаётся числом секунд (10, 0.5) для совместимости
+// с прежними файлами или строкой в формате Go: 30s, 1m30s, 500ms, 2h.
+type Duration time.Duration
+
+//----------------------------------------------------------------------------------------------------------------------
+// Длительность как time.Duration
+//----------------------------------------------------------------------------------------------------------------------
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) Seconds() float64 {
+	return time.Duration(d).Seconds()
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор значения из YAML. Ошибка возвращается как ошибка типа, чтобы декодер продолжил
+// разбор и сообщил обо всех проблемах сразу.
+//----------------------------------------------------------------------------------------------------------------------
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	value, err := ParseDuration(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf(
+			"line %d: неверная длительность %q, ожидается число секунд или строка вида 30s, 1m, 500ms", node.Line, node.Value)}}
+	}
+	*d = value
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор длительности: число секунд или строка в формате time.ParseDuration
+//----------------------------------------------------------------------------------------------------------------------
+func ParseDuration(value string) (Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(seconds) && !math.IsInf(seconds, 0) {
+		return Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := time.ParseDuration(value)
+	return Duration(duration), err
+}
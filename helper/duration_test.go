@@ -0,0 +1,71 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"10", 10 * time.Second, false},
+		{"0.5", 500 * time.Millisecond, false},
+		{"0", 0, false},
+		{"30s", 30 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"2h", 2 * time.Hour, false},
+		{"-5s", -5 * time.Second, false},
+		{"", 0, true},
+		{"ten", 0, true},
+		{"10 s", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseDuration(%q): ошибка %v, ожидается ошибка: %v", test.value, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got.Duration() != test.want {
+			t.Errorf("ParseDuration(%q) = %v, ожидается %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestDurationYAML(t *testing.T) {
+	var value struct {
+		Interval Duration `yaml:"interval"`
+		Timeout  Duration `yaml:"timeout"`
+	}
+	if err := yaml.Unmarshal([]byte("interval: 1m\ntimeout: 2.5\n"), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.Interval.Duration() != time.Minute || value.Timeout.Duration() != 2500*time.Millisecond {
+		t.Errorf("разобрано %v и %v, ожидается 1m0s и 2.5s", value.Interval, value.Timeout)
+	}
+
+	err := yaml.Unmarshal([]byte("interval: [1]\ntimeout: later\n"), &value)
+	typeError, ok := err.(*yaml.TypeError)
+	if !ok || len(typeError.Errors) != 2 {
+		t.Fatalf("ошибка %v, ожидаются две ошибки типа: разбор продолжается после неверного значения", err)
+	}
+	for i, prefix := range []string{"line 1: неверная длительность", "line 2: неверная длительность \"later\""} {
+		if got := typeError.Errors[i]; len(got) < len(prefix) || got[:len(prefix)] != prefix {
+			t.Errorf("ошибка %d: %q, ожидается %q...", i, got, prefix)
+		}
+	}
+
+	data, err := yaml.Marshal(struct {
+		Interval Duration `yaml:"interval"`
+	}{Duration(90 * time.Second)})
+	if err != nil || string(data) != "interval: 1m30s\n" {
+		t.Errorf("сохранено %q, %v, ожидается interval: 1m30s", data, err)
+	}
+}
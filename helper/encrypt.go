@@ -0,0 +1,166 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Длина ключа AES-256
+const encryptionKeySize = 32
+
+// Переменная окружения с путём к файлу ключа, если он не указан в конфигурации
+const EncryptionKeyEnv = "WS_MONITORING_KEY_FILE"
+
+var (
+	encryptionKeyFile string  // файл ключа для значений enc: действующей конфигурации
+	stagedKeyFile     *string // файл ключа загружаемой конфигурации, до её проверки
+)
+
+func init() {
+	RegisterSecretProvider("enc", decryptSecret)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Путь к файлу ключа: из конфигурации или из переменной окружения WS_MONITORING_KEY_FILE.
+// Относительный путь из конфигурации отсчитывается от каталога конфигурации.
+//----------------------------------------------------------------------------------------------------------------------
+func EncryptionKeyFile(cfg *Config) string {
+	if cfg != nil && cfg.EncryptionKeyFile != "" {
+		if filepath.IsAbs(cfg.EncryptionKeyFile) {
+			return cfg.EncryptionKeyFile
+		}
+		return filepath.Join(configDir(), cfg.EncryptionKeyFile)
+	}
+	return os.Getenv(EncryptionKeyEnv)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Создание файла со случайным ключом. Существующий файл не перезаписывается.
+//----------------------------------------------------------------------------------------------------------------------
+func GenerateEncryptionKey(keyFile string) error {
+	if err := checkKeyLocation(keyFile); err != nil {
+		return err
+	}
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(keyFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Загрузка ключа. Файл содержит 32 байта в кодировке base64.
+//----------------------------------------------------------------------------------------------------------------------
+func LoadEncryptionKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("Не указан файл ключа: параметр encryption_key_file или переменная %s", EncryptionKeyEnv)
+	}
+	if err := checkKeyLocation(keyFile); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Не удалось прочитать файл ключа: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("Файл ключа %s повреждён: ожидается %d байт в base64", keyFile, encryptionKeySize)
+	}
+	return key, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Шифрование значения для конфигурации. Возвращает строку вида enc:base64(nonce+шифротекст).
+//----------------------------------------------------------------------------------------------------------------------
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "enc:" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Расшифровка значения enc: ключом из загружаемой конфигурации, вне загрузки - из действующей
+//----------------------------------------------------------------------------------------------------------------------
+func decryptSecret(ref string) (string, error) {
+	keyFile := encryptionKeyFile
+	if stagedKeyFile != nil {
+		keyFile = *stagedKeyFile
+	}
+	key, err := LoadEncryptionKey(keyFile)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ref)
+	if err != nil {
+		return "", fmt.Errorf("Зашифрованное значение повреждено: %v", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("Зашифрованное значение повреждено")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("Не удалось расшифровать значение: неверный ключ или значение повреждено")
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Файл ключа не должен находиться в каталоге конфигурации и его подкаталогах: копия каталога
+// не должна раскрывать пароли
+//----------------------------------------------------------------------------------------------------------------------
+func checkKeyLocation(keyFile string) error {
+	if IsRemoteConfig(ConfigFileName) {
+		return nil
+	}
+	keyPath, err := filepath.Abs(keyFile)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(configDir())
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, keyPath)
+	if err != nil {
+		// Пути на разных дисках: файл ключа заведомо вне каталога конфигурации
+		return nil
+	}
+	if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Файл ключа %s находится в каталоге конфигурации %s, разместите его отдельно", keyFile, dir)
+	}
+	return nil
+}
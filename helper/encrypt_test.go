@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckKeyLocation(t *testing.T) {
+	dir := t.TempDir()
+	saved := ConfigFileName
+	defer func() { ConfigFileName = saved }()
+	ConfigFileName = filepath.Join(dir, "conf", "ws_monitoring.yaml")
+
+	tests := []struct {
+		name    string
+		keyFile string
+		wantErr bool
+	}{
+		{"рядом с конфигурацией", filepath.Join(dir, "conf", "key"), true},
+		{"в подкаталоге конфигурации", filepath.Join(dir, "conf", "keys", "key"), true},
+		{"имя начинается с точек", filepath.Join(dir, "conf", "..key"), true},
+		{"через .. обратно в каталог", filepath.Join(dir, "other", "..", "conf", "key"), true},
+		{"в соседнем каталоге", filepath.Join(dir, "keys", "key"), false},
+		{"в родительском каталоге", filepath.Join(dir, "key"), false},
+		{"каталог с похожим именем", filepath.Join(dir, "conf-keys", "key"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkKeyLocation(test.keyFile)
+			if (err != nil) != test.wantErr {
+				t.Errorf("checkKeyLocation(%s) = %v, ожидается ошибка: %v", test.keyFile, err, test.wantErr)
+			}
+		})
+	}
+
+	ConfigFileName = "https://config.example.com/ws_monitoring.yaml"
+	if err := checkKeyLocation("key"); err != nil {
+		t.Errorf("для конфигурации по HTTP каталог не проверяется: %v", err)
+	}
+}
+
+func TestEncryptionKeyFile(t *testing.T) {
+	saved := ConfigFileName
+	defer func() { ConfigFileName = saved }()
+	ConfigFileName = filepath.Join("etc", "ws", "ws_monitoring.yaml")
+	t.Setenv(EncryptionKeyEnv, "env.key")
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		want string
+	}{
+		{"относительный путь от каталога конфигурации", &Config{EncryptionKeyFile: "../keys/ws.key"}, filepath.Join("etc", "keys", "ws.key")},
+		{"абсолютный путь", &Config{EncryptionKeyFile: "/var/lib/ws/ws.key"}, "/var/lib/ws/ws.key"},
+		{"из переменной окружения", &Config{}, "env.key"},
+		{"без конфигурации", nil, "env.key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := EncryptionKeyFile(test.cfg); got != test.want {
+				t.Errorf("EncryptionKeyFile = %s, ожидается %s", got, test.want)
+			}
+		})
+	}
+}
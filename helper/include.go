@@ -0,0 +1,275 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Подключаемые файлы по умолчанию, если в основном файле нет параметра include
+const defaultInclude = "conf.d/*.yaml"
+
+// Шаблоны отслеживаемых файлов последней прочитанной конфигурации, абсолютные пути
+var watchPatterns []string
+
+// Тип - содержимое подключаемого файла. Списки объединяются со списками основного файла.
+// Подключаемые файлы не могут подключать другие файлы.
+type includeConfig struct {
+	Services    []Service           `yaml:"services"`
+	Canaries    []Canary            `yaml:"canaries"`
+	Maintenance []MaintenanceWindow `yaml:"maintenance"`
+}
+
+// Тип - прочитанный файл конфигурации
+type configSource struct {
+	Name    string
+	Data    []byte
+	Warning string // источник по HTTP недоступен, использована сохранённая копия
+}
+
+// Тип - разобранные файлы конфигурации для поиска строк при проверке
+type configTree struct {
+	root    *yaml.Node          // основной файл
+	origins map[string][]origin // происхождение элементов объединённых списков по имени списка
+}
+
+// Тип - файл и индекс, откуда взят элемент объединённого списка
+type origin struct {
+	file   string // пусто для основного файла
+	root   *yaml.Node
+	index  int
+	prefix path            // для обнаруженного сервиса - путь к источнику, например discovery[0]
+	keys   map[string]bool // для обнаруженного сервиса - заданные параметры
+}
+
+// Параметры, указанные в элементе списка
+func (o origin) presentKeys(list string) map[string]bool {
+	if o.keys == nil {
+		return nodeKeys(path{list, o.index}.node(o.root))
+	}
+	keys := make(map[string]bool, len(o.keys))
+	for key := range o.keys {
+		keys[key] = true
+	}
+	return keys
+}
+
+// Путь к параметру элемента в его файле
+func (o origin) path(p path) path {
+	result := path{p[0], o.index}
+	if o.prefix != nil {
+		result = append(path(nil), o.prefix...)
+	}
+	return append(result, p[2:]...)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Чтение основного файла и всех подключаемых. Основной файл всегда первый, подключаемые -
+// в порядке шаблонов include, файлы одного шаблона по алфавиту.
+//----------------------------------------------------------------------------------------------------------------------
+func readConfigSources(configName string) ([]configSource, error) {
+	main, err := readSource(configName)
+	if err != nil {
+		return nil, err
+	}
+	sources := []configSource{main}
+	data := main.Data
+
+	// Список include нужен до полного разбора, ошибки основного файла сообщит parseConfig
+	var head struct {
+		Include []string `yaml:"include"`
+	}
+	yaml.Unmarshal(data, &head)
+	patterns := IncludePatterns(configName, head.Include)
+	setWatchPatterns(configName, patterns)
+
+	seen := map[string]bool{sourceKey(configName): true}
+	for _, pattern := range patterns {
+		names := []string{pattern}
+		// Отсутствующий файл без шаблона - ошибка, шаблон может не найти ни одного файла
+		if !IsRemoteConfig(pattern) && hasGlobMeta(pattern) {
+			if names, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("Неверный шаблон include %q: %v", pattern, err)
+			}
+		}
+		for _, name := range names {
+			if seen[sourceKey(name)] {
+				continue
+			}
+			seen[sourceKey(name)] = true
+			source, err := readSource(name)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+// Чтение файла с диска или по HTTP
+func readSource(name string) (configSource, error) {
+	source := configSource{Name: name}
+	var err error
+	if IsRemoteConfig(name) {
+		source.Data, source.Warning, err = readRemote(name)
+	} else {
+		source.Data, err = ioutil.ReadFile(name)
+	}
+	return source, err
+}
+
+func sourceKey(name string) string {
+	if IsRemoteConfig(name) {
+		return name
+	}
+	return absPath(name)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Шаблоны подключаемых файлов. Относительные пути отсчитываются от каталога основного файла,
+// для основного файла по HTTP - от его адреса. Подключаемый файл может быть задан адресом http(s)://.
+// Без параметра include подключаются файлы conf.d/*.yaml рядом с основным файлом на диске.
+//----------------------------------------------------------------------------------------------------------------------
+func IncludePatterns(configName string, include []string) []string {
+	remote := IsRemoteConfig(configName)
+	if include == nil && !remote {
+		include = []string{defaultInclude}
+	}
+	patterns := make([]string, 0, len(include))
+	for _, pattern := range include {
+		switch {
+		case IsRemoteConfig(pattern) || filepath.IsAbs(pattern):
+		case remote:
+			base, _ := url.Parse(configName)
+			if ref, err := url.Parse(pattern); err == nil {
+				pattern = base.ResolveReference(ref).String()
+			}
+		default:
+			pattern = filepath.Join(filepath.Dir(configName), pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Шаблоны файлов последней прочитанной конфигурации для отслеживания изменений: основной файл, include
+// и файлы источников обнаружения
+//----------------------------------------------------------------------------------------------------------------------
+func ConfigWatchPatterns() []string {
+	return append(append([]string(nil), watchPatterns...), discovery.files()...)
+}
+
+// Файлы по HTTP не отслеживаются, их изменения обнаруживаются периодической проверкой
+func setWatchPatterns(configName string, include []string) {
+	var patterns []string
+	for _, name := range append([]string{configName}, include...) {
+		if !IsRemoteConfig(name) {
+			patterns = append(patterns, absPath(name))
+		}
+	}
+	watchPatterns = patterns
+}
+
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[`)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Объединение списков подключаемых файлов со списками основного файла
+//----------------------------------------------------------------------------------------------------------------------
+func (c *Config) include(tree *configTree, files []configSource) (problems []Problem) {
+	tree.origins = make(map[string][]origin)
+	tree.record("services", "", tree.root, len(c.Services))
+	tree.record("canaries", "", tree.root, len(c.Canaries))
+	tree.record("maintenance", "", tree.root, len(c.Maintenance))
+
+	for _, file := range files {
+		c.Files = append(c.Files, file.Name)
+		if file.Warning != "" {
+			c.Warnings = append(c.Warnings, file.Warning)
+		}
+		var root yaml.Node
+		if err := yaml.Unmarshal(file.Data, &root); err != nil {
+			problems = append(problems, inFile(file.Name, yamlProblems(err))...)
+			continue
+		}
+		var part includeConfig
+		decoder := yaml.NewDecoder(bytes.NewReader(file.Data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&part); err != nil && err != io.EOF {
+			problems = append(problems, inFile(file.Name, yamlProblems(err))...)
+			if _, ok := err.(*yaml.TypeError); !ok {
+				continue
+			}
+		}
+		tree.record("services", file.Name, &root, len(part.Services))
+		tree.record("canaries", file.Name, &root, len(part.Canaries))
+		tree.record("maintenance", file.Name, &root, len(part.Maintenance))
+		c.Services = append(c.Services, part.Services...)
+		c.Canaries = append(c.Canaries, part.Canaries...)
+		c.Maintenance = append(c.Maintenance, part.Maintenance...)
+	}
+	return problems
+}
+
+func (t *configTree) record(list string, file string, root *yaml.Node, count int) {
+	for i := 0; i < count; i++ {
+		t.origins[list] = append(t.origins[list], origin{file: file, root: root, index: i})
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Файл и индекс, откуда взят элемент объединённого списка по пути вида services[5].address
+//----------------------------------------------------------------------------------------------------------------------
+func (t *configTree) origin(p path) (origin, bool) {
+	if len(p) < 2 {
+		return origin{}, false
+	}
+	list, ok := p[0].(string)
+	index, ok2 := p[1].(int)
+	if !ok || !ok2 || index >= len(t.origins[list]) {
+		return origin{}, false
+	}
+	return t.origins[list][index], true
+}
+
+func inFile(name string, problems []Problem) []Problem {
+	for i := range problems {
+		problems[i].File = name
+	}
+	return problems
+}
+
+func checksumOf(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Контрольная сумма всех файлов конфигурации. Для одного файла совпадает с SHA-256 его содержимого.
+func sourcesChecksum(sources []configSource) string {
+	hash := sha256.New()
+	for i, source := range sources {
+		if i > 0 {
+			fmt.Fprintf(hash, "\x00%s\x00", source.Name)
+		}
+		hash.Write(source.Data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,177 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	remoteTimeout = 30 * time.Second
+	remoteMaxSize = 16 << 20 // предельный размер загружаемого файла
+)
+
+// Переменная окружения с каталогом для копий конфигурации, загруженной по HTTP
+const RemoteCacheEnv = "WS_MONITORING_CACHE_DIR"
+
+// Каталог для последних успешно загруженных копий конфигурации по HTTP. Копия используется,
+// если источник недоступен, в том числе при запуске.
+var RemoteCacheDir = defaultRemoteCacheDir()
+
+// Тип - последняя успешно загруженная копия файла конфигурации по HTTP
+type remoteEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Body         string    `json:"body"`
+	FetchedAt    time.Time `json:"fetched_at"` // время последнего успешного ответа источника
+
+	// Загруженное содержимое, которое ещё не прошло разбор и проверку конфигурации. Становится
+	// последней корректной копией только после успешной загрузки конфигурации (commitRemote).
+	pending *remoteEntry
+}
+
+var (
+	remoteMutex   sync.Mutex
+	remoteEntries = make(map[string]*remoteEntry)
+	remoteClient  = &http.Client{Timeout: remoteTimeout}
+)
+
+func defaultRemoteCacheDir() string {
+	if dir := os.Getenv(RemoteCacheEnv); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "ws_monitoring")
+	}
+	return ""
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Задан ли файл конфигурации адресом http(s)://
+//----------------------------------------------------------------------------------------------------------------------
+func IsRemoteConfig(name string) bool {
+	u, err := url.Parse(name)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Загрузка файла конфигурации по HTTP. Повторные запросы условные (If-None-Match, If-Modified-Since):
+// при ответе 304 используется сохранённая копия. Если источник недоступен, возвращается последняя
+// успешно загруженная копия и предупреждение; ошибка - только если копии нет.
+//----------------------------------------------------------------------------------------------------------------------
+func readRemote(address string) (data []byte, warning string, err error) {
+	remoteMutex.Lock()
+	defer remoteMutex.Unlock()
+
+	if u, err := url.Parse(address); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			AddSecretValue(password)
+		}
+	}
+	entry := remoteEntries[address]
+	if entry == nil {
+		entry = &remoteEntry{}
+		// Повреждённая копия не мешает загрузке из источника
+		if LoadState(RemoteCacheDir, remoteCacheName(address), entry) != nil || entry.URL != address {
+			entry = &remoteEntry{URL: address}
+		}
+		remoteEntries[address] = entry
+	}
+
+	if data, err = entry.fetch(); err == nil {
+		return data, "", nil
+	}
+	if entry.FetchedAt.IsZero() {
+		return nil, "", fmt.Errorf("Не удалось загрузить конфигурацию: %v", err)
+	}
+	warning = fmt.Sprintf("%s недоступен (%v), используется копия от %s", RedactSecrets(address), err,
+		entry.FetchedAt.Format("2006-01-02 15:04:05"))
+	return []byte(entry.Body), warning, nil
+}
+
+func (e *remoteEntry) fetch() ([]byte, error) {
+	e.pending = nil
+	request, err := http.NewRequest("GET", e.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !e.FetchedAt.IsZero() {
+		if e.ETag != "" {
+			request.Header.Set("If-None-Match", e.ETag)
+		}
+		if e.LastModified != "" {
+			request.Header.Set("If-Modified-Since", e.LastModified)
+		}
+	}
+	response, err := remoteClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotModified && !e.FetchedAt.IsZero():
+		e.FetchedAt = time.Now()
+		return []byte(e.Body), nil
+	case response.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("ответ %s", response.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, remoteMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > remoteMaxSize {
+		return nil, fmt.Errorf("размер файла больше %d байт", remoteMaxSize)
+	}
+	e.pending = &remoteEntry{
+		URL:          e.URL,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		Body:         string(body),
+		FetchedAt:    time.Now(),
+	}
+	return body, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Сохранение загруженных по HTTP файлов как последних корректных копий. Вызывается только после
+// успешного разбора и проверки конфигурации: ошибочный или обрезанный ответ источника не заменяет
+// копию, по которой программа запустится при недоступности источника.
+//----------------------------------------------------------------------------------------------------------------------
+func commitRemote(sources []configSource) {
+	remoteMutex.Lock()
+	defer remoteMutex.Unlock()
+	for _, source := range sources {
+		entry := remoteEntries[source.Name]
+		if entry == nil || entry.pending == nil {
+			continue
+		}
+		*entry = *entry.pending
+		// Копия нужна только на случай недоступности источника, ошибка записи не мешает работе
+		SaveState(RemoteCacheDir, remoteCacheName(entry.URL), entry)
+	}
+}
+
+func remoteCacheName(address string) string {
+	sum := sha256.Sum256([]byte(address))
+	return "remote-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Каталог для относительных путей в конфигурации: каталог файла, для конфигурации по HTTP - текущий
+//----------------------------------------------------------------------------------------------------------------------
+func configDir() string {
+	if IsRemoteConfig(ConfigFileName) {
+		return "."
+	}
+	return filepath.Dir(ConfigFileName)
+}
@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+// Сохранение состояния в файл каталога состояния в формате JSON.
+// Запись выполняется через временный файл, чтобы при сбое не повредить предыдущую копию.
+// Если каталог состояния не задан, ничего не делает.
+//----------------------------------------------------------------------------------------------------------------------
+func SaveState(dir string, name string, v interface{}) error {
+	if dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fileName := filepath.Join(dir, name)
+	tmpName := fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpName, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Загрузка состояния, сохранённого SaveState.
+// Отсутствие файла ошибкой не считается, v при этом не изменяется.
+//----------------------------------------------------------------------------------------------------------------------
+func LoadState(dir string, name string, v interface{}) error {
+	if dir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,169 @@
+package helper
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Предельное число сервисов, порождаемых одним описанием с матрицей
+const maxMatrixServices = 10000
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение шаблонов и умолчаний к сервисам и развёртывание матриц.
+// Параметр сервиса берётся из шаблона, если не указан в сервисе, затем из defaults, если не указан и в шаблоне.
+// Метки и коды ответа дополняются ключами шаблона и defaults.
+//----------------------------------------------------------------------------------------------------------------------
+func (c *Config) applyTemplates(tree *configTree) []Problem {
+	v := &validator{tree: tree}
+	defaultKeys := nodeKeys(path{"defaults"}.node(tree.root))
+	templateKeys := make(map[string]map[string]bool, len(c.Templates))
+	for name, template := range c.Templates {
+		templateKeys[name] = nodeKeys(path{"templates", name}.node(tree.root))
+		if template.Template != "" {
+			v.add(path{"templates", name, "template"}, "шаблон не может ссылаться на другой шаблон")
+		}
+	}
+	if c.Defaults != nil && c.Defaults.Template != "" {
+		v.add(path{"defaults", "template"}, "в defaults нельзя указать шаблон")
+	}
+
+	services := make([]Service, 0, len(c.Services))
+	origins := make([]origin, 0, len(c.Services))
+	for i, service := range c.Services {
+		o := tree.origins["services"][i]
+		present := o.presentKeys("services")
+		if service.Template != "" {
+			if template, ok := c.Templates[service.Template]; ok {
+				inherit(&service, present, template, templateKeys[service.Template])
+			} else {
+				v.add(path{"services", i, "template"}, "неизвестный шаблон %q", service.Template)
+			}
+		}
+		if c.Defaults != nil {
+			inherit(&service, present, *c.Defaults, defaultKeys)
+		}
+		expanded, err := expandMatrix(service)
+		if err != nil {
+			v.add(path{"services", i, "matrix"}, "%v", err)
+		}
+		for range expanded {
+			origins = append(origins, o)
+		}
+		services = append(services, expanded...)
+	}
+	c.Services = services
+	tree.origins["services"] = origins
+	return v.problems
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Заполнение параметров сервиса, которые в нём не указаны, из шаблона. present и srcPresent - ключи,
+// указанные в YAML сервиса и шаблона: так явное enabled: false в сервисе не заменяется значением шаблона.
+//----------------------------------------------------------------------------------------------------------------------
+func inherit(dst *Service, present map[string]bool, src Service, srcPresent map[string]bool) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src)
+	for i := 0; i < dv.NumField(); i++ {
+		key := strings.Split(dv.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "name" || key == "template" || !srcPresent[key] {
+			continue
+		}
+		if !present[key] {
+			dv.Field(i).Set(sv.Field(i))
+			present[key] = true
+			continue
+		}
+		if dv.Field(i).Kind() == reflect.Map && !sv.Field(i).IsNil() {
+			merged := reflect.MakeMap(dv.Field(i).Type())
+			for _, m := range []reflect.Value{sv.Field(i), dv.Field(i)} {
+				for _, k := range m.MapKeys() {
+					merged.SetMapIndex(k, m.MapIndex(k))
+				}
+			}
+			dv.Field(i).Set(merged)
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Развёртывание матрицы: по сервису на каждое сочетание значений переменных. Ссылки {переменная}
+// в имени, адресе, логине, пароле, метках и зависимостях заменяются значениями.
+//----------------------------------------------------------------------------------------------------------------------
+func expandMatrix(service Service) ([]Service, error) {
+	if len(service.Matrix) == 0 {
+		return []Service{service}, nil
+	}
+	names := make([]string, 0, len(service.Matrix))
+	count := 1
+	for name, values := range service.Matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("пустой список значений переменной %s", name)
+		}
+		names = append(names, name)
+		if count *= len(values); count > maxMatrixServices {
+			return nil, fmt.Errorf("матрица порождает больше %d сервисов", maxMatrixServices)
+		}
+	}
+	sort.Strings(names)
+
+	combinations := []map[string]string{{}}
+	for _, name := range names {
+		next := make([]map[string]string, 0, len(combinations)*len(service.Matrix[name]))
+		for _, combination := range combinations {
+			for _, value := range service.Matrix[name] {
+				extended := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					extended[k] = v
+				}
+				extended[name] = value
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+
+	services := make([]Service, 0, len(combinations))
+	for _, combination := range combinations {
+		pairs := make([]string, 0, 2*len(combination))
+		for name, value := range combination {
+			pairs = append(pairs, "{"+name+"}", value)
+		}
+		r := strings.NewReplacer(pairs...)
+		s := service
+		s.Matrix = nil
+		s.Name = r.Replace(s.Name)
+		s.Address = r.Replace(s.Address)
+		s.Login = r.Replace(s.Login)
+		s.Password = Secret(r.Replace(string(s.Password)))
+		if s.Tags != nil {
+			s.Tags = make(map[string]string, len(service.Tags))
+			for k, v := range service.Tags {
+				s.Tags[k] = r.Replace(v)
+			}
+		}
+		if s.DependsOn != nil {
+			s.DependsOn = make([]string, len(service.DependsOn))
+			for i, name := range service.DependsOn {
+				s.DependsOn[i] = r.Replace(name)
+			}
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// Ключи узла-словаря YAML
+func nodeKeys(node *yaml.Node) map[string]bool {
+	keys := make(map[string]bool)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return keys
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys[node.Content[i].Value] = true
+	}
+	return keys
+}
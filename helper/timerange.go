@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Форматы времени разового окна обслуживания
+var windowTimeFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка корректности интервала времени суток
+//----------------------------------------------------------------------------------------------------------------------
+func (r TimeRange) Validate() error {
+	if _, err := parseClock(r.Start, 0); err != nil {
+		return err
+	}
+	if _, err := parseClock(r.End, 24*60); err != nil {
+		return err
+	}
+	for _, item := range r.Weekdays {
+		for _, bound := range strings.SplitN(item, "-", 2) {
+			if _, ok := weekdays[strings.ToLower(strings.TrimSpace(bound))]; !ok {
+				return fmt.Errorf("Неизвестный день недели %q, допустимо: mon, tue, wed, thu, fri, sat, sun", bound)
+			}
+		}
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка попадания момента времени в интервал времени суток
+//----------------------------------------------------------------------------------------------------------------------
+func (r TimeRange) Contains(now time.Time) bool {
+	start, err := parseClock(r.Start, 0)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(r.End, 24*60)
+	if err != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	day := now.Weekday()
+	if start <= end {
+		return minute >= start && minute < end && weekdayMatches(r.Weekdays, day)
+	}
+	// Интервал через полночь: хвост после полуночи относится к предыдущему дню
+	if minute >= start {
+		return weekdayMatches(r.Weekdays, day)
+	}
+	return minute < end && weekdayMatches(r.Weekdays, (day+6)%7)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор времени суток в формате ЧЧ:ММ, возвращает число минут от полуночи
+//----------------------------------------------------------------------------------------------------------------------
+func parseClock(value string, empty int) (int, error) {
+	if value == "" {
+		return empty, nil
+	}
+	var hour, minute int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("Неверный формат времени %q, ожидается ЧЧ:ММ", value)
+	}
+	if hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute > 0) {
+		return 0, fmt.Errorf("Неверное время %q", value)
+	}
+	return hour*60 + minute, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка дня недели по списку вида mon, wed-fri
+//----------------------------------------------------------------------------------------------------------------------
+func weekdayMatches(list []string, day time.Weekday) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		bounds := strings.SplitN(strings.ToLower(strings.TrimSpace(item)), "-", 2)
+		from, ok := weekdays[strings.TrimSpace(bounds[0])]
+		if !ok {
+			continue
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[strings.TrimSpace(bounds[1])]; !ok {
+				continue
+			}
+		}
+		if from <= to {
+			if day >= from && day <= to {
+				return true
+			}
+		} else if day >= from || day <= to {
+			return true
+		}
+	}
+	return false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор времени начала или окончания разового окна обслуживания
+//----------------------------------------------------------------------------------------------------------------------
+func ParseWindowTime(value string, location *time.Location) (t time.Time, err error) {
+	for _, format := range windowTimeFormats {
+		if t, err = time.ParseInLocation(format, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
@@ -0,0 +1,86 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRangeValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       TimeRange
+		wantErr bool
+	}{
+		{"весь день", TimeRange{}, false},
+		{"рабочее время", TimeRange{Weekdays: []string{"mon-fri"}, Start: "09:00", End: "18:00"}, false},
+		{"до конца суток", TimeRange{Start: "22:00", End: "24:00"}, false},
+		{"неверный формат", TimeRange{Start: "9am"}, true},
+		{"неверный час", TimeRange{Start: "25:00"}, true},
+		{"неверная минута", TimeRange{End: "10:60"}, true},
+		{"после 24:00", TimeRange{End: "24:01"}, true},
+		{"неизвестный день", TimeRange{Weekdays: []string{"mon", "fun"}}, true},
+		{"неизвестный день в диапазоне", TimeRange{Weekdays: []string{"mon-frd"}}, true},
+	}
+	for _, test := range tests {
+		if err := test.r.Validate(); (err != nil) != test.wantErr {
+			t.Errorf("%s: ошибка %v, ожидается ошибка: %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestTimeRangeContains(t *testing.T) {
+	// 4 марта 2024 года - понедельник
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	office := TimeRange{Weekdays: []string{"mon-fri"}, Start: "09:00", End: "18:00"}
+	night := TimeRange{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}
+	weekend := TimeRange{Weekdays: []string{"sat-sun"}}
+	wrapWeek := TimeRange{Weekdays: []string{"fri-mon"}}
+	tests := []struct {
+		name string
+		r    TimeRange
+		now  time.Time
+		want bool
+	}{
+		{"начало включается", office, at(4, 9, 0), true},
+		{"конец не включается", office, at(4, 18, 0), false},
+		{"до начала", office, at(4, 8, 59), false},
+		{"выходной", office, at(9, 12, 0), false},
+		{"ночь пятницы до полуночи", night, at(8, 23, 0), true},
+		{"ночь пятницы после полуночи", night, at(9, 5, 59), true},
+		{"ночь четверга после полуночи", night, at(8, 5, 0), false},
+		{"ночь пятницы закончилась", night, at(9, 6, 0), false},
+		{"весь день выходного", weekend, at(10, 0, 0), true},
+		{"будний день", weekend, at(4, 12, 0), false},
+		{"диапазон через воскресенье", wrapWeek, at(10, 12, 0), true},
+		{"диапазон через воскресенье, понедельник", wrapWeek, at(4, 12, 0), true},
+		{"диапазон через воскресенье, среда", wrapWeek, at(6, 12, 0), false},
+		{"неверный интервал не совпадает", TimeRange{Start: "bad"}, at(4, 12, 0), false},
+	}
+	for _, test := range tests {
+		if got := test.r.Contains(test.now); got != test.want {
+			t.Errorf("%s: Contains(%s) = %v, ожидается %v", test.name, test.now.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
+
+func TestParseWindowTime(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*3600)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-03-04 22:00", time.Date(2024, 3, 4, 22, 0, 0, 0, moscow), false},
+		{"2024-03-04 22:00:30", time.Date(2024, 3, 4, 22, 0, 30, 0, moscow), false},
+		{"2024-03-04T22:00:00Z", time.Date(2024, 3, 4, 22, 0, 0, 0, time.UTC), false},
+		{"04.03.2024 22:00", time.Time{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseWindowTime(test.value, moscow)
+		if (err != nil) != test.wantErr || (!test.wantErr && !got.Equal(test.want)) {
+			t.Errorf("ParseWindowTime(%q) = %v, %v, ожидается %v", test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package helper
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem - ошибка в конфигурации с номером строки
+type Problem struct {
+	File    string // подключаемый файл, пусто для основного
+	Line    int    // 0, если строку определить не удалось
+	Path    string // путь к параметру, например services[2].address
+	Message string
+}
+
+// ConfigError - все ошибки конфигурации, найденные за один проход
+type ConfigError struct {
+	File     string
+	Problems []Problem
+}
+
+var (
+	yamlLineRe     = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	statusCodeRe   = regexp.MustCompile(`^([1-5][0-9][0-9]|[1-5]xx)$`)
+	logLevels      = []string{"DEBUG", "INFO", "ERROR"}
+	severityValues = []string{"ok", "warning", "critical"}
+	alertSeverity  = []string{"critical", "warning"}
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+// Текст ошибки со списком всех найденных проблем
+//----------------------------------------------------------------------------------------------------------------------
+func (e *ConfigError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("Ошибки в конфигурации %s (%d):", e.File, len(e.Problems)))
+	for _, p := range e.Problems {
+		line := "  "
+		if p.File != "" {
+			line += "файл " + p.File + ", "
+		}
+		if p.Line > 0 {
+			line += fmt.Sprintf("строка %d: ", p.Line)
+		}
+		if p.Path != "" {
+			line += p.Path + ": "
+		}
+		lines = append(lines, line+p.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Разбор ошибок YAML-декодера: декодер возвращает все ошибки типов сразу, каждую с номером строки
+//----------------------------------------------------------------------------------------------------------------------
+func yamlProblems(err error) []Problem {
+	var messages []string
+	if typeError, ok := err.(*yaml.TypeError); ok {
+		messages = typeError.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+	problems := make([]Problem, 0, len(messages))
+	for _, message := range messages {
+		p := Problem{Message: message}
+		if m := yamlLineRe.FindStringSubmatch(message); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = m[2]
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// Тип - проверка конфигурации с поиском строк по дереву узлов YAML
+type validator struct {
+	tree     *configTree
+	problems []Problem
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка конфигурации. Возвращает все найденные проблемы.
+//----------------------------------------------------------------------------------------------------------------------
+func (c *Config) validate(tree *configTree) []Problem {
+	v := &validator{tree: tree}
+
+	if !contains(logLevels, strings.ToUpper(c.LogLevel)) {
+		v.add(path{"log_level"}, "неизвестный уровень лога %q, допустимо: %s", c.LogLevel, strings.Join(logLevels, ", "))
+	}
+	if c.LogFilename == "" {
+		v.add(path{"log_filename"}, "не указан файл лога")
+	}
+	if c.DataCollectorURL == "" {
+		v.add(path{"data_collector_url"}, "не указан адрес сборщика данных")
+	} else {
+		v.checkURL(path{"data_collector_url"}, c.DataCollectorURL)
+	}
+	if c.ReloadConfigInterval < 0 {
+		v.add(path{"reload_config_interval"}, "интервал должен быть положительным")
+	}
+	if c.MaxCheckThreads < 0 {
+		v.add(path{"max_check_threads"}, "число потоков проверки должно быть положительным")
+	}
+	if c.MissedHeartbeats < 0 {
+		v.add(path{"watchdog_missed_heartbeats"}, "число контрольных сигналов должно быть положительным")
+	}
+	if c.HTTPListen != "" {
+		v.checkHostPort(path{"http_listen"}, c.HTTPListen)
+	}
+	if c.ExternalURL != "" {
+		v.checkURL(path{"external_url"}, c.ExternalURL)
+	}
+	if c.Vault != nil {
+		v.checkURL(path{"vault", "address"}, c.Vault.Address)
+		if c.Vault.Token == "" && c.Vault.RoleID == "" {
+			v.add(path{"vault"}, "укажите token или role_id и secret_id")
+		}
+		if c.Vault.RoleID != "" && c.Vault.SecretID == "" {
+			v.add(path{"vault", "secret_id"}, "для входа через AppRole нужен secret_id")
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, service := range c.Services {
+		v.service(path{"services", i}, service, names)
+	}
+	for i, canary := range c.Canaries {
+		p := path{"canaries", i}
+		if canary.Name == "" {
+			v.add(p, "не указано имя сетевой проверки")
+		} else if names[canary.Name] {
+			v.add(p.with("name"), "имя %q уже используется", canary.Name)
+		}
+		names[canary.Name] = true
+		v.checkHostPort(p.with("address"), canary.Address)
+		if canary.CheckInterval < 0 {
+			v.add(p.with("check_interval"), "интервал должен быть положительным")
+		}
+	}
+	v.discovery(c)
+	v.dependencies(c, names)
+	v.alerting(&c.Alerting)
+	for i, window := range c.Maintenance {
+		v.maintenance(path{"maintenance", i}, window)
+	}
+	return v.problems
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка настроек сервиса
+//----------------------------------------------------------------------------------------------------------------------
+func (v *validator) service(p path, service Service, names map[string]bool) {
+	if service.Address == "" {
+		v.add(p, "не указан адрес сервиса")
+	} else {
+		v.checkURL(p.with("address"), service.Address)
+	}
+	if names[service.Name] {
+		v.add(p.with("name"), "имя сервиса %q уже используется", service.Name)
+	}
+	names[service.Name] = true
+	if service.Schedule != "" {
+		if _, err := ParseSchedule(service.Schedule); err != nil {
+			v.add(p.with("schedule"), "%v", err)
+		}
+	} else if service.Enabled && service.CheckInterval <= 0 {
+		v.add(p.with("check_interval"), "интервал проверки включённого сервиса должен быть больше нуля или задано расписание schedule")
+	}
+	if service.Jitter < 0 {
+		v.add(p.with("jitter"), "задержка не может быть отрицательной")
+	}
+	if service.WarnLatency < 0 || service.CritLatency < 0 {
+		v.add(p, "пороги времени ответа не могут быть отрицательными")
+	}
+	if service.WarnLatency > 0 && service.CritLatency > 0 && service.WarnLatency > service.CritLatency {
+		v.add(p.with("warn_latency"), "порог WARNING (%v) больше порога CRITICAL (%v)", service.WarnLatency, service.CritLatency)
+	}
+	for code, severity := range service.StatusCodes {
+		if !statusCodeRe.MatchString(strings.ToLower(code)) {
+			v.add(p.with("status_codes"), "неверный код %q, ожидается код или класс, например 404 или 4xx", code)
+		}
+		if !contains(severityValues, strings.ToLower(severity)) {
+			v.add(p.with("status_codes").with(code), "неизвестный уровень %q, допустимо: %s", severity, strings.Join(severityValues, ", "))
+		}
+	}
+	if slo := service.SLO; slo != nil {
+		if slo.Availability <= 0 || slo.Availability >= 100 {
+			v.add(p.with("slo").with("availability"), "цель доступности должна быть больше 0 и меньше 100")
+		}
+		if slo.LatencyObjective < 0 || slo.LatencyObjective >= 100 {
+			v.add(p.with("slo").with("latency_objective"), "доля проверок должна быть больше 0 и меньше 100")
+		}
+		if slo.LatencyObjective > 0 && slo.Latency <= 0 {
+			v.add(p.with("slo").with("latency"), "не указан порог времени ответа")
+		}
+		if slo.WindowDays < 0 {
+			v.add(p.with("slo").with("window_days"), "длина окна должна быть положительной")
+		}
+	}
+	if anomaly := service.Anomaly; anomaly != nil {
+		if anomaly.Alpha < 0 || anomaly.Alpha >= 1 {
+			v.add(p.with("anomaly").with("alpha"), "коэффициент сглаживания должен быть от 0 до 1")
+		}
+		if anomaly.Threshold < 0 || anomaly.Sustained < 0 || anomaly.MinSamples < 0 {
+			v.add(p.with("anomaly"), "параметры не могут быть отрицательными")
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка источников обнаружения сервисов
+//----------------------------------------------------------------------------------------------------------------------
+func (v *validator) discovery(c *Config) {
+	providers := make(map[string]bool)
+	for i, provider := range c.Discovery {
+		p := path{"discovery", i}
+		if provider.Name == "" {
+			v.add(p, "не указано имя источника")
+		} else if providers[provider.Name] {
+			v.add(p.with("name"), "имя источника %q уже используется", provider.Name)
+		}
+		providers[provider.Name] = true
+		switch provider.Type {
+		case DiscoveryFile:
+			if provider.File == "" {
+				v.add(p.with("file"), "не указан файл")
+			}
+		case DiscoveryDNS:
+			if provider.SRV == "" && provider.Host == "" {
+				v.add(p, "укажите srv или host")
+			}
+			if provider.Scheme != "" && provider.Scheme != "http" && provider.Scheme != "https" {
+				v.add(p.with("scheme"), "допустимо http или https")
+			}
+		case DiscoveryHTTP:
+			v.checkURL(p.with("url"), provider.URL)
+		default:
+			v.add(p.with("type"), "неизвестный тип источника %q, допустимо: %s", provider.Type, strings.Join(discoveryTypes, ", "))
+		}
+		if provider.CheckInterval < 0 || provider.RefreshInterval < 0 {
+			v.add(p, "интервалы не могут быть отрицательными")
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка зависимостей сервисов: ссылки на существующие имена и отсутствие циклов
+//----------------------------------------------------------------------------------------------------------------------
+func (v *validator) dependencies(c *Config, names map[string]bool) {
+	graph := make(map[string][]string)
+	for i, service := range c.Services {
+		for j, name := range service.DependsOn {
+			if !names[name] {
+				v.add(path{"services", i, "depends_on", j}, "неизвестная зависимость %q", name)
+			} else if name == service.Name {
+				v.add(path{"services", i, "depends_on", j}, "сервис не может зависеть от самого себя")
+			}
+		}
+		graph[service.Name] = service.DependsOn
+	}
+	// Поиск циклов обходом в глубину
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int)
+	var visit func(name string, stack []string) []string
+	visit = func(name string, stack []string) []string {
+		switch marks[name] {
+		case visiting:
+			return append(stack, name)
+		case done:
+			return nil
+		}
+		marks[name] = visiting
+		for _, parent := range graph[name] {
+			if parent == name {
+				continue
+			}
+			if cycle := visit(parent, append(stack, name)); cycle != nil {
+				return cycle
+			}
+		}
+		marks[name] = done
+		return nil
+	}
+	for i, service := range c.Services {
+		if cycle := visit(service.Name, nil); cycle != nil {
+			v.add(path{"services", i, "depends_on"}, "циклическая зависимость: %s", strings.Join(cycle, " -> "))
+			return
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка настроек оповещений
+//----------------------------------------------------------------------------------------------------------------------
+func (v *validator) alerting(a *AlertingConfig) {
+	receivers := make(map[string]bool)
+	for i, receiver := range a.Receivers {
+		p := path{"alerting", "receivers", i}
+		if receiver.Name == "" {
+			v.add(p, "не указано имя получателя")
+		} else if receivers[receiver.Name] {
+			v.add(p.with("name"), "имя получателя %q уже используется", receiver.Name)
+		}
+		receivers[receiver.Name] = true
+		for j, webhook := range receiver.Webhooks {
+			v.checkURL(p.with("webhooks").with(j).with("url"), webhook.URL)
+		}
+		for j, email := range receiver.Emails {
+			ep := p.with("emails").with(j)
+			if len(email.To) == 0 {
+				v.add(ep.with("to"), "не указаны адресаты")
+			}
+			if email.From == "" {
+				v.add(ep.with("from"), "не указан отправитель")
+			}
+			v.checkHostPort(ep.with("smarthost"), email.SmartHost)
+		}
+	}
+
+	policies := make(map[string]bool)
+	for i, policy := range a.EscalationPolicies {
+		p := path{"alerting", "escalation_policies", i}
+		if policy.Name == "" {
+			v.add(p, "не указано имя политики эскалации")
+		} else if policies[policy.Name] {
+			v.add(p.with("name"), "имя политики %q уже используется", policy.Name)
+		}
+		policies[policy.Name] = true
+		if len(policy.Tiers) == 0 {
+			v.add(p, "не указаны уровни эскалации")
+		}
+		for j, tier := range policy.Tiers {
+			tp := p.with("tiers").with(j)
+			if !receivers[tier.Receiver] {
+				v.add(tp.with("receiver"), "неизвестный получатель %q", tier.Receiver)
+			}
+			if tier.Delay < 0 || (j > 0 && tier.Delay < policy.Tiers[j-1].Delay) {
+				v.add(tp.with("delay"), "задержки уровней должны быть неотрицательными и не убывать")
+			}
+		}
+	}
+
+	if a.Route != nil {
+		if a.Route.Receiver == "" {
+			v.add(path{"alerting", "route", "receiver"}, "у корневого маршрута должен быть получатель")
+		}
+		v.route(path{"alerting", "route"}, a.Route, receivers, policies)
+	}
+}
+
+func (v *validator) route(p path, route *Route, receivers map[string]bool, policies map[string]bool) {
+	if route.Receiver != "" && !receivers[route.Receiver] {
+		v.add(p.with("receiver"), "неизвестный получатель %q", route.Receiver)
+	}
+	if route.Escalation != "" && !policies[route.Escalation] {
+		v.add(p.with("escalation"), "неизвестная политика эскалации %q", route.Escalation)
+	}
+	route.MatchRegexp = make(map[string]*regexp.Regexp, len(route.MatchRE))
+	for name, expr := range route.MatchRE {
+		re, err := CompileMatchRE(expr)
+		if err != nil {
+			v.add(p.with("match_re").with(name), "неверное регулярное выражение: %v", err)
+			continue
+		}
+		route.MatchRegexp[name] = re
+	}
+	for i, severity := range route.Severity {
+		if !contains(alertSeverity, strings.ToLower(severity)) {
+			v.add(p.with("severity").with(i), "неизвестный уровень %q, допустимо: %s", severity, strings.Join(alertSeverity, ", "))
+		}
+	}
+	for i, timeRange := range route.ActiveTime {
+		if err := timeRange.Validate(); err != nil {
+			v.add(p.with("active_time").with(i), "%v", err)
+		}
+	}
+	if route.GroupWait < 0 || route.GroupInterval < 0 || route.RepeatInterval < 0 {
+		v.add(p, "интервалы группировки не могут быть отрицательными")
+	}
+	for i, child := range route.Routes {
+		v.route(p.with("routes").with(i), child, receivers, policies)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка окна обслуживания
+//----------------------------------------------------------------------------------------------------------------------
+func (v *validator) maintenance(p path, window MaintenanceWindow) {
+	defined := false
+	if window.Start != "" || window.End != "" {
+		defined = true
+		start, err := ParseWindowTime(window.Start, time.Local)
+		if err != nil {
+			v.add(p.with("start"), "неверное время %q, ожидается 2006-01-02 15:04", window.Start)
+		}
+		end, err2 := ParseWindowTime(window.End, time.Local)
+		if err2 != nil {
+			v.add(p.with("end"), "неверное время %q, ожидается 2006-01-02 15:04", window.End)
+		}
+		if err == nil && err2 == nil && !end.After(start) {
+			v.add(p.with("end"), "окончание окна должно быть позже начала")
+		}
+	}
+	if window.Schedule != "" {
+		defined = true
+		if _, err := ParseSchedule(window.Schedule); err != nil {
+			v.add(p.with("schedule"), "%v", err)
+		}
+		if window.Duration <= 0 {
+			v.add(p.with("duration"), "для окна по расписанию нужна длительность больше нуля")
+		}
+	}
+	for i, timeRange := range window.TimeRanges {
+		defined = true
+		if err := timeRange.Validate(); err != nil {
+			v.add(p.with("time_ranges").with(i), "%v", err)
+		}
+	}
+	if !defined {
+		v.add(p, "окно не задано: укажите start/end, schedule или time_ranges")
+	}
+}
+
+func (v *validator) checkURL(p path, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(p, "неверный адрес %q, ожидается http(s)://host/...", value)
+	}
+}
+
+func (v *validator) checkHostPort(p path, value string) {
+	if _, port, err := net.SplitHostPort(value); err != nil || port == "" {
+		v.add(p, "неверный адрес %q, ожидается host:port", value)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Добавление проблемы. Для элемента объединённого списка указываются файл и путь в нём, откуда взят элемент.
+//----------------------------------------------------------------------------------------------------------------------
+func (v *validator) add(p path, format string, args ...interface{}) {
+	problem := Problem{Message: fmt.Sprintf(format, args...)}
+	root := v.tree.root
+	if o, ok := v.tree.origin(p); ok {
+		problem.File, root = o.file, o.root
+		p = o.path(p)
+	}
+	problem.Line = p.line(root)
+	problem.Path = p.String()
+	// Обнаруженные сервисы одного источника дают одинаковые проблемы
+	for _, existing := range v.problems {
+		if existing == problem {
+			return
+		}
+	}
+	v.problems = append(v.problems, problem)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Тип - путь к параметру: имена ключей и индексы элементов списков
+type path []interface{}
+
+func (p path) with(element interface{}) path {
+	result := make(path, len(p), len(p)+1)
+	copy(result, p)
+	return append(result, element)
+}
+
+func (p path) String() string {
+	var b strings.Builder
+	for _, element := range p {
+		switch e := element.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", e)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			fmt.Fprint(&b, e)
+		}
+	}
+	return b.String()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Узел параметра в дереве YAML, nil если параметр отсутствует
+//----------------------------------------------------------------------------------------------------------------------
+func (p path) node(root *yaml.Node) *yaml.Node {
+	if root == nil {
+		return nil
+	}
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, element := range p {
+		var next *yaml.Node
+		switch e := element.(type) {
+		case string:
+			for i := 0; node.Kind == yaml.MappingNode && i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == e {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && e < len(node.Content) {
+				next = node.Content[e]
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Номер строки параметра в исходном файле. Если параметр отсутствует, возвращается строка
+// ближайшего существующего родителя.
+//----------------------------------------------------------------------------------------------------------------------
+func (p path) line(root *yaml.Node) int {
+	if root == nil {
+		return 0
+	}
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, element := range p {
+		switch e := element.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return line
+			}
+			found := false
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == e {
+					line = node.Content[i].Line
+					node = node.Content[i+1]
+					found = true
+					break
+				}
+			}
+			if !found {
+				return line
+			}
+		case int:
+			if node.Kind != yaml.SequenceNode || e >= len(node.Content) {
+				return line
+			}
+			node = node.Content[e]
+			line = node.Line
+		}
+	}
+	return line
+}
@@ -0,0 +1,121 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Начало корректной конфигурации для тестов проверки
+const validConfigHead = `log_filename: test.log
+data_collector_url: http://collector/
+`
+
+func parseTestConfig(data string) (*Config, error) {
+	return parseConfig("test.yaml", []configSource{{Name: "test.yaml", Data: []byte(data)}})
+}
+
+func TestValidateProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string // строка, путь и начало сообщения каждой ошибки
+	}{
+		{"корректная конфигурация", validConfigHead + `
+services:
+  - name: a
+    address: http://a/
+    check_interval: 10s
+`, nil},
+		{"неизвестные параметры", validConfigHead + `servces: []
+services:
+  - name: a
+    address: http://a/
+    check_interval: 10s
+    jiter: 1s
+`, []string{
+			"3 : field servces not found",
+			"8 : field jiter not found",
+		}},
+		{"ошибки сервисов", validConfigHead + `services:
+  - name: a
+    address: http://a/
+    check_interval: 10s
+  - name: a
+    address: "ftp//x"
+    check_interval: ten
+`, []string{
+			"7 services[1].name: имя сервиса \"a\" уже используется",
+			"8 services[1].address: неверный адрес",
+			"9 : неверная длительность \"ten\"",
+		}},
+		{"пороги, расписание и коды ответа", validConfigHead + `services:
+  - name: a
+    address: http://a/
+    schedule: "61 * * * *"
+    warn_latency: 2s
+    crit_latency: 1s
+    status_codes: {"6xx": critical}
+`, []string{
+			"6 services[0].schedule: Поле минута",
+			"7 services[0].warn_latency: порог WARNING (2s) больше порога CRITICAL (1s)",
+			"9 services[0].status_codes: неверный код \"6xx\"",
+		}},
+		{"маршруты оповещений", validConfigHead + `alerting:
+  receivers: [{name: r}]
+  route:
+    receiver: r
+    routes:
+      - match_re: {service: "a("}
+        receiver: x
+`, []string{
+			"8 alerting.route.routes[0].match_re.service: неверное регулярное выражение",
+			"9 alerting.route.routes[0].receiver: неизвестный получатель \"x\"",
+		}},
+		{"не указаны обязательные параметры", "services: []\n", []string{
+			"1 log_filename: не указан файл лога",
+			"1 data_collector_url: не указан адрес сборщика данных",
+		}},
+		{"синтаксическая ошибка", "services: [\n", []string{
+			"1 : did not find expected node content",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTestConfig(test.config)
+			var got []string
+			if err != nil {
+				configError, ok := err.(*ConfigError)
+				if !ok {
+					t.Fatalf("ошибка %T, ожидается *ConfigError: %v", err, err)
+				}
+				for _, p := range configError.Problems {
+					got = append(got, fmt.Sprintf("%d %s: %s", p.Line, p.Path, p.Message))
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("ошибки:\n%s\nожидается:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], test.want[i]) {
+					t.Errorf("ошибка %d: %q, ожидается %q...", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConfigErrorText(t *testing.T) {
+	err := &ConfigError{File: "ws.yaml", Problems: []Problem{
+		{Line: 3, Path: "services[0].address", Message: "неверный адрес"},
+		{File: "services.yaml", Line: 7, Message: "field x not found"},
+		{Path: "log_filename", Message: "не указан файл лога"},
+	}}
+	want := "Ошибки в конфигурации ws.yaml (3):\n" +
+		"  строка 3: services[0].address: неверный адрес\n" +
+		"  файл services.yaml, строка 7: field x not found\n" +
+		"  log_filename: не указан файл лога"
+	if got := err.Error(); got != want {
+		t.Errorf("текст ошибки:\n%s\nожидается:\n%s", got, want)
+	}
+}
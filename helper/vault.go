@@ -0,0 +1,466 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultVaultMount           = "secret"
+	defaultVaultRefreshInterval = Duration(5 * time.Minute)
+	vaultRequestTimeout         = 10 * time.Second
+	// Минимальный интервал между внеочередными обновлениями, например после ответа 401
+	vaultInvalidateInterval = 30 * time.Second
+)
+
+// VaultConfig - подключение к HashiCorp Vault для ссылок вида vault:путь#поле.
+// Секреты читаются из хранилища KV версии 2. Аутентификация по токену или через AppRole.
+type VaultConfig struct {
+	Address         string   `yaml:"address"`          // например https://vault.example.com:8200
+	Token           Secret   `yaml:"token"`            // токен, если не используется AppRole
+	RoleID          string   `yaml:"role_id"`          // AppRole
+	SecretID        Secret   `yaml:"secret_id"`        // AppRole
+	Mount           string   `yaml:"mount"`            // точка монтирования KV, по умолчанию secret
+	Namespace       string   `yaml:"namespace"`        // пространство имён Vault Enterprise
+	RefreshInterval Duration `yaml:"refresh_interval"` // период повторного чтения секретов, по умолчанию 5m
+}
+
+// Тип - прочитанный секрет Vault: все поля записи
+type vaultEntry struct {
+	data    map[string]string
+	version int
+}
+
+// Тип - подключение к Vault: настройки и токен. Запросы выполняются с копией подключения
+// без блокировки vaultClient, полученный при входе или продлении токен затем сохраняется.
+type vaultSession struct {
+	config       *VaultConfig
+	http         *http.Client
+	token        string
+	tokenExpires time.Time // нулевое время - токен бессрочный
+	renewable    bool
+}
+
+// vaultClient - синглтон, кэширует секреты и продлевает токен
+type vaultClient struct {
+	mutex       sync.Mutex
+	session     vaultSession
+	generation  int                    // номер настроек: результаты запросов с прежними настройками отбрасываются
+	cache       map[string]*vaultEntry // путь -> запись
+	referenced  map[string]bool        // пути, на которые ссылается действующая конфигурация
+	invalidated time.Time
+	started     bool
+
+	// Настройки загружаемой конфигурации, которая ещё не прошла проверку. Становятся действующими только
+	// после успешной загрузки конфигурации (commit), отклонённая конфигурация не меняет подключение.
+	pending *vaultPending
+}
+
+// Тип - подключение и секреты загружаемой конфигурации
+type vaultPending struct {
+	changed    bool                   // настройки отличаются от действующих, используются session и cache
+	session    vaultSession           // при changed
+	cache      map[string]*vaultEntry // при changed
+	referenced map[string]bool        // пути, прочитанные при загрузке
+}
+
+var (
+	vault          = vaultClient{session: vaultSession{http: &http.Client{Timeout: vaultRequestTimeout}}}
+	secretsChanged []func()
+)
+
+func init() {
+	RegisterSecretProvider("vault", vault.read)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Регистрация функции, вызываемой при изменении секретов во внешнем хранилище
+//----------------------------------------------------------------------------------------------------------------------
+func OnSecretsChanged(hook func()) {
+	secretsChanged = append(secretsChanged, hook)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Внеочередное обновление секретов, например после отказа в доступе к сервису из-за смены пароля.
+// Выполняется в фоне и не чаще раза в vaultInvalidateInterval.
+//----------------------------------------------------------------------------------------------------------------------
+func RefreshSecrets() {
+	vault.mutex.Lock()
+	if vault.session.config == nil || time.Since(vault.invalidated) < vaultInvalidateInterval {
+		vault.mutex.Unlock()
+		return
+	}
+	vault.invalidated = time.Now()
+	vault.mutex.Unlock()
+	go vault.refresh()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Настройки подключения загружаемой конфигурации. Ссылки vault: этой конфигурации читаются с новыми настройками,
+// действующее подключение, кэш и фоновое обновление не меняются до commit.
+//----------------------------------------------------------------------------------------------------------------------
+func (v *vaultClient) stage(cfg *VaultConfig) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if cfg != nil {
+		copied := *cfg
+		if copied.Mount == "" {
+			copied.Mount = defaultVaultMount
+		}
+		if copied.RefreshInterval <= 0 {
+			copied.RefreshInterval = defaultVaultRefreshInterval
+		}
+		cfg = &copied
+	}
+	v.pending = &vaultPending{referenced: make(map[string]bool)}
+	if !reflect.DeepEqual(v.session.config, cfg) {
+		v.pending.changed = true
+		v.pending.session = vaultSession{config: cfg, http: v.session.http}
+		v.pending.cache = make(map[string]*vaultEntry)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение настроек после успешной загрузки конфигурации. При изменении настроек подключение и кэш заменяются
+// прочитанными при загрузке. Секреты, на которые конфигурация больше не ссылается, удаляются из кэша
+// и больше не перечитываются при обновлении.
+//----------------------------------------------------------------------------------------------------------------------
+func (v *vaultClient) commit() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	pending := v.pending
+	if pending == nil {
+		return
+	}
+	v.pending = nil
+	v.referenced = pending.referenced
+	if pending.changed {
+		v.session = pending.session
+		v.cache = pending.cache
+		v.generation++
+		if v.session.config != nil && !v.started {
+			v.started = true
+			go v.loop()
+		}
+	}
+	for path := range v.cache {
+		if !v.referenced[path] {
+			delete(v.cache, path)
+		}
+	}
+}
+
+// Отказ от настроек отклонённой конфигурации: действующее подключение и кэш не меняются
+func (v *vaultClient) discard() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.pending = nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Чтение значения по ссылке путь#поле. Значение берётся из кэша, если запись уже прочитана.
+// Во время загрузки конфигурации используются её настройки подключения. Запрос к Vault выполняется без блокировки.
+//----------------------------------------------------------------------------------------------------------------------
+func (v *vaultClient) read(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("Неверная ссылка vault:%s, ожидается vault:путь#поле", ref)
+	}
+	path, field := ref[:i], ref[i+1:]
+
+	v.mutex.Lock()
+	pending := v.pending
+	session, cache, generation, referenced := v.session, v.cache, v.generation, v.referenced
+	if pending != nil {
+		referenced = pending.referenced
+		if pending.changed {
+			session, cache = pending.session, pending.cache
+		}
+	}
+	if session.config == nil {
+		v.mutex.Unlock()
+		return "", fmt.Errorf("Ссылка vault:%s, но подключение к Vault не настроено", ref)
+	}
+	if referenced == nil {
+		referenced = make(map[string]bool)
+		v.referenced = referenced
+	}
+	referenced[path] = true
+	entry, ok := cache[path]
+	v.mutex.Unlock()
+
+	if !ok {
+		var err error
+		if entry, err = session.fetch(path); err != nil {
+			return "", err
+		}
+		v.mutex.Lock()
+		switch {
+		case pending != nil && pending.changed:
+			// Результат сохраняется, только если загрузка конфигурации ещё не завершена
+			if v.pending == pending {
+				pending.session = session
+				pending.cache[path] = entry
+			}
+		case generation == v.generation:
+			v.session = session
+			v.cache[path] = entry
+		}
+		v.mutex.Unlock()
+	}
+	value, ok := entry.data[field]
+	if !ok {
+		return "", fmt.Errorf("В секрете Vault %s нет поля %s", path, field)
+	}
+	return value, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Фоновое продление токена и повторное чтение секретов
+//----------------------------------------------------------------------------------------------------------------------
+func (v *vaultClient) loop() {
+	for {
+		v.mutex.Lock()
+		interval := defaultVaultRefreshInterval.Duration()
+		if v.session.config != nil {
+			interval = v.session.config.RefreshInterval.Duration()
+		}
+		// Токен продлевается, когда прошло больше половины срока его действия
+		if !v.session.tokenExpires.IsZero() {
+			if untilRenew := time.Until(v.session.tokenExpires) / 2; untilRenew < interval {
+				interval = untilRenew
+			}
+		}
+		v.mutex.Unlock()
+		if interval < time.Second {
+			interval = time.Second
+		}
+		time.Sleep(interval)
+		v.refresh()
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Продление токена и повторное чтение всех закэшированных секретов.
+// Если какое-либо значение изменилось, вызываются функции OnSecretsChanged.
+// Запросы к Vault выполняются без блокировки, результаты применяются, если настройки не изменились.
+//----------------------------------------------------------------------------------------------------------------------
+func (v *vaultClient) refresh() {
+	v.mutex.Lock()
+	if v.session.config == nil {
+		v.mutex.Unlock()
+		return
+	}
+	session, generation := v.session, v.generation
+	paths := make([]string, 0, len(v.cache))
+	for path := range v.cache {
+		paths = append(paths, path)
+	}
+	v.mutex.Unlock()
+
+	if session.token != "" && !session.tokenExpires.IsZero() {
+		if err := session.renew(); err != nil {
+			// Токен не продлён: при следующем запросе будет выполнен повторный вход
+			session.token = ""
+		}
+	}
+	fetched := make(map[string]*vaultEntry, len(paths))
+	for _, path := range paths {
+		// При ошибке остаётся прежнее значение: недоступность Vault не должна останавливать проверки
+		if entry, err := session.fetch(path); err == nil {
+			fetched[path] = entry
+		}
+	}
+
+	v.mutex.Lock()
+	if generation != v.generation {
+		v.mutex.Unlock()
+		return
+	}
+	v.session = session
+	changed := false
+	for path, entry := range fetched {
+		old, ok := v.cache[path]
+		if !ok {
+			// Путь удалён из кэша, пока выполнялись запросы
+			continue
+		}
+		if !reflect.DeepEqual(entry.data, old.data) {
+			changed = true
+		}
+		v.cache[path] = entry
+	}
+	v.mutex.Unlock()
+
+	if changed {
+		for _, hook := range secretsChanged {
+			hook()
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Чтение записи KV версии 2. При отказе в доступе выполняется повторный вход.
+//----------------------------------------------------------------------------------------------------------------------
+func (session *vaultSession) fetch(path string) (*vaultEntry, error) {
+	var response struct {
+		Data struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	url := "/v1/" + strings.Trim(session.config.Mount, "/") + "/data/" + strings.TrimLeft(path, "/")
+	status, err := session.authorizedRequest("GET", url, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("Секрет Vault %s не найден", path)
+	}
+	entry := &vaultEntry{data: make(map[string]string, len(response.Data.Data)), version: response.Data.Metadata.Version}
+	for key, value := range response.Data.Data {
+		if s, ok := value.(string); ok {
+			entry.data[key] = s
+		} else {
+			entry.data[key] = fmt.Sprint(value)
+		}
+	}
+	return entry, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запрос с токеном. Вход выполняется при первом запросе и повторно, если токен отозван или истёк.
+//----------------------------------------------------------------------------------------------------------------------
+func (session *vaultSession) authorizedRequest(method string, url string, body interface{}, result interface{}) (int, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if session.token == "" {
+			if err := session.login(); err != nil {
+				return 0, err
+			}
+		}
+		status, err := session.request(method, url, session.token, body, result)
+		if status == http.StatusForbidden && attempt == 0 {
+			session.token = ""
+			continue
+		}
+		return status, err
+	}
+	return 0, fmt.Errorf("Vault: доступ запрещён к %s", url)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Вход в Vault: через AppRole или проверка указанного токена
+//----------------------------------------------------------------------------------------------------------------------
+func (session *vaultSession) login() error {
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+			Renewable     bool   `json:"renewable"`
+		} `json:"auth"`
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if session.config.RoleID != "" {
+		body := map[string]string{"role_id": session.config.RoleID, "secret_id": session.config.SecretID.Value()}
+		if _, err := session.request("POST", "/v1/auth/approle/login", "", body, &response); err != nil {
+			return fmt.Errorf("Vault: ошибка входа через AppRole: %v", err)
+		}
+		session.setToken(response.Auth.ClientToken, response.Auth.LeaseDuration, response.Auth.Renewable)
+		return nil
+	}
+	if session.config.Token == "" {
+		return fmt.Errorf("Vault: не указан токен или role_id")
+	}
+	if _, err := session.request("GET", "/v1/auth/token/lookup-self", session.config.Token.Value(), nil, &response); err != nil {
+		return fmt.Errorf("Vault: токен не действителен: %v", err)
+	}
+	session.setToken(session.config.Token.Value(), response.Data.TTL, response.Data.Renewable)
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Продление токена
+//----------------------------------------------------------------------------------------------------------------------
+func (session *vaultSession) renew() error {
+	if !session.renewable {
+		return fmt.Errorf("Vault: токен не продлевается")
+	}
+	var response struct {
+		Auth struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		} `json:"auth"`
+	}
+	if _, err := session.request("POST", "/v1/auth/token/renew-self", session.token, struct{}{}, &response); err != nil {
+		return err
+	}
+	session.setToken(session.token, response.Auth.LeaseDuration, response.Auth.Renewable)
+	return nil
+}
+
+func (session *vaultSession) setToken(token string, ttl int, renewable bool) {
+	session.token = token
+	session.renewable = renewable
+	session.tokenExpires = time.Time{}
+	if ttl > 0 {
+		session.tokenExpires = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+	AddSecretValue(token)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// HTTP-запрос к Vault. Возвращает код ответа; ответ 404 не считается ошибкой.
+//----------------------------------------------------------------------------------------------------------------------
+func (session *vaultSession) request(method string, url string, token string, body interface{}, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(session.config.Address, "/")+url, reader)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if session.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", session.config.Namespace)
+	}
+	resp, err := session.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 300:
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(data, &e)
+		return resp.StatusCode, fmt.Errorf("ответ %s %s", resp.Status, strings.Join(e.Errors, "; "))
+	}
+	return resp.StatusCode, json.Unmarshal(data, result)
+}
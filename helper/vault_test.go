@@ -0,0 +1,344 @@
+package helper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Тип - тестовый Vault: токен root, AppRole app/secret, хранилище KV версии 2 в secret/
+type fakeVault struct {
+	mutex    sync.Mutex
+	secrets  map[string]map[string]string
+	tokens   map[string]bool
+	logins   int
+	renewals int
+	reads    map[string]int
+	block    chan struct{} // если задан, чтение секрета slow ждёт закрытия канала
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	f := &fakeVault{
+		secrets: map[string]map[string]string{"app": {"password": "p1"}},
+		tokens:  map[string]bool{"root": true},
+		reads:   make(map[string]int),
+	}
+	server := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "app" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mutex.Lock()
+		f.logins++
+		f.tokens["approle-token"] = true
+		f.mutex.Unlock()
+		reply(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": "approle-token", "lease_duration": 3600, "renewable": true}})
+		return
+	}
+
+	f.mutex.Lock()
+	valid := f.tokens[r.Header.Get("X-Vault-Token")]
+	f.mutex.Unlock()
+	if !valid {
+		w.WriteHeader(http.StatusForbidden)
+		reply(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/auth/token/lookup-self":
+		f.mutex.Lock()
+		f.logins++
+		f.mutex.Unlock()
+		reply(map[string]interface{}{"data": map[string]interface{}{"ttl": 3600, "renewable": true}})
+	case r.URL.Path == "/v1/auth/token/renew-self":
+		f.mutex.Lock()
+		f.renewals++
+		f.mutex.Unlock()
+		reply(map[string]interface{}{"auth": map[string]interface{}{"lease_duration": 3600, "renewable": true}})
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		if path == "slow" && f.block != nil {
+			<-f.block
+		}
+		f.mutex.Lock()
+		f.reads[path]++
+		data, ok := f.secrets[path]
+		f.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reply(map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": map[string]int{"version": 1}}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeVault) set(path string, field string, value string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.secrets[path] == nil {
+		f.secrets[path] = make(map[string]string)
+	}
+	f.secrets[path][field] = value
+}
+
+func (f *fakeVault) count(counter *int) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return *counter
+}
+
+func (f *fakeVault) readsOf(path string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.reads[path]
+}
+
+// Клиент без фонового обновления: обновление в тестах вызывается явно
+func newTestVaultClient(cfg *VaultConfig) *vaultClient {
+	v := &vaultClient{session: vaultSession{http: &http.Client{Timeout: vaultRequestTimeout}}, started: true}
+	v.stage(cfg)
+	v.commit()
+	return v
+}
+
+func TestVaultTokenAuth(t *testing.T) {
+	f, server := newFakeVault(t)
+	v := newTestVaultClient(&VaultConfig{Address: server.URL, Token: "root"})
+	value, err := v.read("app#password")
+	if err != nil || value != "p1" {
+		t.Fatalf("read = %q, %v, ожидается p1", value, err)
+	}
+	if _, err := v.read("app#password"); err != nil {
+		t.Fatal(err)
+	}
+	if logins, reads := f.count(&f.logins), f.readsOf("app"); logins != 1 || reads != 1 {
+		t.Errorf("входов %d, чтений %d, ожидается по одному: значение берётся из кэша", logins, reads)
+	}
+
+	tests := []struct {
+		name string
+		cfg  VaultConfig
+		ref  string
+	}{
+		{"неверный токен", VaultConfig{Address: server.URL, Token: "wrong"}, "app#password"},
+		{"нет секрета", VaultConfig{Address: server.URL, Token: "root"}, "missing#password"},
+		{"нет поля", VaultConfig{Address: server.URL, Token: "root"}, "app#login"},
+		{"неверная ссылка", VaultConfig{Address: server.URL, Token: "root"}, "app"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := test.cfg
+			if _, err := newTestVaultClient(&cfg).read(test.ref); err == nil {
+				t.Errorf("read(%q) без ошибки", test.ref)
+			}
+		})
+	}
+}
+
+func TestVaultAppRoleLogin(t *testing.T) {
+	f, server := newFakeVault(t)
+	v := newTestVaultClient(&VaultConfig{Address: server.URL, RoleID: "app", SecretID: "secret"})
+	value, err := v.read("app#password")
+	if err != nil || value != "p1" {
+		t.Fatalf("read = %q, %v, ожидается p1", value, err)
+	}
+	if v.session.token != "approle-token" || f.count(&f.logins) != 1 {
+		t.Errorf("токен %q, входов %d", v.session.token, f.count(&f.logins))
+	}
+
+	// Отозванный токен: повторный вход при следующем запросе
+	f.mutex.Lock()
+	delete(f.tokens, "approle-token")
+	f.mutex.Unlock()
+	if _, err := newTestVaultClient(&VaultConfig{Address: server.URL, RoleID: "app", SecretID: "wrong"}).read("app#password"); err == nil {
+		t.Error("вход с неверным secret_id без ошибки")
+	}
+	v.refresh()
+	if f.count(&f.logins) != 2 {
+		t.Errorf("входов %d, ожидается повторный вход после отзыва токена", f.count(&f.logins))
+	}
+}
+
+func TestVaultRenewal(t *testing.T) {
+	f, server := newFakeVault(t)
+	v := newTestVaultClient(&VaultConfig{Address: server.URL, Token: "root"})
+	if _, err := v.read("app#password"); err != nil {
+		t.Fatal(err)
+	}
+	expires := v.session.tokenExpires
+	if expires.IsZero() || !v.session.renewable {
+		t.Fatalf("срок токена %v, продлеваемый %v", expires, v.session.renewable)
+	}
+	time.Sleep(10 * time.Millisecond)
+	v.refresh()
+	if f.count(&f.renewals) != 1 {
+		t.Errorf("продлений %d, ожидается 1", f.count(&f.renewals))
+	}
+	if !v.session.tokenExpires.After(expires) {
+		t.Errorf("срок токена не продлён: %v", v.session.tokenExpires)
+	}
+}
+
+func TestVaultRotation(t *testing.T) {
+	f, server := newFakeVault(t)
+	v := newTestVaultClient(&VaultConfig{Address: server.URL, Token: "root"})
+	if _, err := v.read("app#password"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := 0
+	saved := secretsChanged
+	secretsChanged = []func(){func() { changed++ }}
+	defer func() { secretsChanged = saved }()
+
+	v.refresh()
+	if changed != 0 {
+		t.Errorf("изменений %d без смены пароля", changed)
+	}
+	f.set("app", "password", "p2")
+	v.refresh()
+	if changed != 1 {
+		t.Errorf("изменений %d после смены пароля, ожидается 1", changed)
+	}
+	if value, _ := v.read("app#password"); value != "p2" {
+		t.Errorf("read = %q, ожидается новый пароль p2", value)
+	}
+}
+
+func TestVaultPrune(t *testing.T) {
+	f, server := newFakeVault(t)
+	f.set("old", "password", "x")
+	cfg := &VaultConfig{Address: server.URL, Token: "root"}
+	v := newTestVaultClient(cfg)
+	v.read("app#password")
+	v.read("old#password")
+
+	// Следующая загрузка конфигурации больше не ссылается на old
+	v.stage(cfg)
+	v.read("app#password")
+	v.commit()
+	v.refresh()
+	if _, ok := v.cache["old"]; ok {
+		t.Error("секрет old остался в кэше")
+	}
+	if reads := f.readsOf("old"); reads != 1 {
+		t.Errorf("чтений old %d, ожидается 1: удалённый путь не перечитывается", reads)
+	}
+}
+
+// Отклонённая конфигурация читает секреты со своими настройками, но не меняет действующее подключение и кэш
+func TestVaultRejectedConfigKeepsSession(t *testing.T) {
+	f, server := newFakeVault(t)
+	other, otherServer := newFakeVault(t)
+	other.set("app", "password", "other")
+	cfg := &VaultConfig{Address: server.URL, Token: "root"}
+	v := newTestVaultClient(cfg)
+	v.stage(cfg)
+	if _, err := v.read("app#password"); err != nil {
+		t.Fatal(err)
+	}
+	v.commit()
+
+	v.stage(&VaultConfig{Address: otherServer.URL, Token: "root"})
+	if value, err := v.read("app#password"); err != nil || value != "other" {
+		t.Fatalf("read = %q, %v, ожидается значение из нового подключения", value, err)
+	}
+	v.discard()
+	if v.session.config.Address != server.URL || v.cache["app"] == nil || !v.referenced["app"] {
+		t.Fatalf("подключение %s, кэш %v, ссылки %v: изменены отклонённой конфигурацией",
+			v.session.config.Address, v.cache, v.referenced)
+	}
+	v.refresh()
+	if reads, otherReads := f.readsOf("app"), other.readsOf("app"); reads != 2 || otherReads != 1 {
+		t.Errorf("чтений %d и %d, ожидается обновление через действующее подключение", reads, otherReads)
+	}
+
+	// Принятая конфигурация заменяет подключение и кэш
+	v.stage(&VaultConfig{Address: otherServer.URL, Token: "root"})
+	v.read("app#password")
+	v.commit()
+	if value, _ := v.read("app#password"); v.session.config.Address != otherServer.URL || value != "other" {
+		t.Errorf("подключение %s, значение %q после применения конфигурации", v.session.config.Address, value)
+	}
+}
+
+func TestVaultSlowRequestDoesNotBlockCache(t *testing.T) {
+	f, server := newFakeVault(t)
+	f.set("slow", "password", "s")
+	f.block = make(chan struct{})
+	defer close(f.block)
+	v := newTestVaultClient(&VaultConfig{Address: server.URL, Token: "root"})
+	if _, err := v.read("app#password"); err != nil {
+		t.Fatal(err)
+	}
+
+	go v.read("slow#password")
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		v.read("app#password")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("чтение из кэша ждёт медленного запроса к Vault")
+	}
+}
+
+// Ответ сервиса 401 вызывает RefreshSecrets: внеочередное обновление выполняется в фоне
+// и не чаще раза в vaultInvalidateInterval
+func TestRefreshSecretsAfterUnauthorized(t *testing.T) {
+	f, server := newFakeVault(t)
+	savedHooks := secretsChanged
+	vault.mutex.Lock()
+	vault.started = true
+	vault.mutex.Unlock()
+	defer func() {
+		vault.stage(nil)
+		vault.commit()
+		vault.mutex.Lock()
+		vault.started = false
+		vault.invalidated = time.Time{}
+		vault.mutex.Unlock()
+		secretsChanged = savedHooks
+	}()
+	vault.stage(&VaultConfig{Address: server.URL, Token: "root"})
+	vault.commit()
+	if _, err := vault.read("app#password"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 2)
+	secretsChanged = []func(){func() { changed <- struct{}{} }}
+	f.set("app", "password", "p2")
+	RefreshSecrets()
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("смена пароля не обнаружена после RefreshSecrets")
+	}
+
+	f.set("app", "password", "p3")
+	RefreshSecrets()
+	time.Sleep(100 * time.Millisecond)
+	if len(changed) != 0 {
+		t.Error("повторное обновление раньше vaultInvalidateInterval")
+	}
+}
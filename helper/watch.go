@@ -0,0 +1,114 @@
+package helper
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Задержка перед сигналом об изменении: редакторы сохраняют файл за несколько операций
+const watchDebounce = 500 * time.Millisecond
+
+// ErrWatchUnsupported - отслеживание изменений файлов не поддерживается на этой платформе
+var ErrWatchUnsupported = errors.New("Отслеживание изменений файлов не поддерживается")
+
+// ConfigWatcher - отслеживание изменений конфигурационных файлов.
+// Отслеживаются каталоги файлов, поэтому замена файла переименованием при сохранении не теряется.
+type ConfigWatcher struct {
+	Events   chan struct{} // сигнал об изменении файла после подавления дребезга
+	mutex    sync.Mutex
+	timer    *time.Timer
+	patterns []string // абсолютные пути или шаблоны отслеживаемых файлов
+	closer   io.Closer
+	fd       int
+	watches  map[string]int // каталог -> дескриптор отслеживания
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск отслеживания изменений файлов по шаблонам, см. ConfigWatchPatterns
+//----------------------------------------------------------------------------------------------------------------------
+func WatchConfig(patterns []string) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{Events: make(chan struct{}, 1), watches: make(map[string]int)}
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+	if err := w.Update(patterns); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Замена списка отслеживаемых файлов, например после изменения include
+//----------------------------------------------------------------------------------------------------------------------
+func (w *ConfigWatcher) Update(patterns []string) error {
+	w.mutex.Lock()
+	w.patterns = patterns
+	w.mutex.Unlock()
+	return w.watch(watchDirs(patterns))
+}
+
+// Каталоги, в которых могут появиться файлы по шаблонам
+func watchDirs(patterns []string) []string {
+	set := make(map[string]bool)
+	for _, pattern := range patterns {
+		dir := filepath.Dir(pattern)
+		if !hasGlobMeta(dir) {
+			set[dir] = true
+			continue
+		}
+		matches, _ := filepath.Glob(dir)
+		for _, match := range matches {
+			set[match] = true
+		}
+	}
+	dirs := make([]string, 0, len(set))
+	for dir := range set {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Относится ли файл к отслеживаемым
+func (w *ConfigWatcher) matches(name string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка отслеживания
+//----------------------------------------------------------------------------------------------------------------------
+func (w *ConfigWatcher) Close() error {
+	w.mutex.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mutex.Unlock()
+	return w.closer.Close()
+}
+
+// Изменение файла: сигнал отправляется, когда изменения прекратятся на время watchDebounce
+func (w *ConfigWatcher) changed() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(watchDebounce, func() {
+		select {
+		case w.Events <- struct{}{}:
+		default:
+		}
+	})
+}
@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// События каталога, после которых файл мог измениться
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_ATTRIB
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отслеживание через inotify
+//----------------------------------------------------------------------------------------------------------------------
+func (w *ConfigWatcher) start() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// Неблокирующий дескриптор обслуживается планировщиком Go, поэтому Close прерывает чтение
+	file := os.NewFile(uintptr(fd), "inotify")
+	w.closer, w.fd = file, fd
+
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				start := offset + syscall.SizeofInotifyEvent
+				end := start + int(event.Len)
+				offset = end
+				if end > n {
+					break
+				}
+				if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+					w.changed()
+					continue
+				}
+				dir, ok := w.watchedDir(int(event.Wd), event.Mask&syscall.IN_IGNORED != 0)
+				if ok && w.matches(filepath.Join(dir, cString(buf[start:end]))) {
+					w.changed()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отслеживание указанных каталогов: новые добавляются, лишние снимаются.
+// Несуществующие каталоги пропускаются: шаблон include может ещё не найти ни одного файла.
+//----------------------------------------------------------------------------------------------------------------------
+func (w *ConfigWatcher) watch(dirs []string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	wanted := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		wanted[dir] = true
+		if _, ok := w.watches[dir]; ok {
+			continue
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			continue
+		}
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.watches[dir] = wd
+	}
+	for dir, wd := range w.watches {
+		if !wanted[dir] {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, dir)
+		}
+	}
+	return nil
+}
+
+// Каталог по дескриптору отслеживания. Отслеживание удалённого каталога снимается системой.
+func (w *ConfigWatcher) watchedDir(wd int, removed bool) (string, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for dir, watched := range w.watches {
+		if watched == wd {
+			if removed {
+				delete(w.watches, dir)
+			}
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// Имя файла в событии дополнено нулевыми байтами
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package helper
+
+//----------------------------------------------------------------------------------------------------------------------
+// На других платформах изменения обнаруживаются только периодической проверкой
+//----------------------------------------------------------------------------------------------------------------------
+func (w *ConfigWatcher) start() error {
+	return ErrWatchUnsupported
+}
+
+func (w *ConfigWatcher) watch(dirs []string) error {
+	return nil
+}
@@ -2,6 +2,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -25,15 +26,52 @@ func InitLogger(cfg *helper.Config) error {
 		logger = logrus.New()
 	}
 	// Инициализация файла лога - выполняется однократно
+	var err error
 	initOnce.Do(func() {
-		file, err := os.OpenFile(cfg.LogFilename, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0666)
-		if err != nil {
-			panic(err)
+		var file *os.File
+		if file, err = os.OpenFile(cfg.LogFilename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666); err == nil {
+			logger.Out = file
 		}
-		logger.Out = file
 	})
+	if err != nil {
+		return fmt.Errorf("Не удалось открыть файл лога: %v", err)
+	}
 	// Настройка уровня логирования
-	switch strings.ToUpper(cfg.LogLevel) {
+	if err := setLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	// Настройка формата времени
+	logger.Formatter = redactingFormatter{&logrus.TextFormatter{TimestampFormat: "2006-01-02 15:04:05"}}
+	// Информационное сообщение
+	logger.Infoln("Уровень логирования", cfg.LogLevel)
+	// Ошибок не было
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Инициализация логгера для однократных команд командной строки
+//----------------------------------------------------------------------------------------------------------------------
+func InitConsoleLogger(out io.Writer, level string) error {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	logger.Out = out
+	logger.Formatter = redactingFormatter{&logrus.TextFormatter{TimestampFormat: "2006-01-02 15:04:05"}}
+	return setLevel(level)
+}
+
+// Тип - форматирование записей журнала с вычищением значений секретов
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data, err := f.Formatter.Format(entry)
+	return helper.RedactSecretsBytes(data), err
+}
+
+func setLevel(level string) error {
+	switch strings.ToUpper(level) {
 	case "DEBUG":
 		logger.Level = logrus.DebugLevel
 	case "INFO":
@@ -41,13 +79,8 @@ func InitLogger(cfg *helper.Config) error {
 	case "ERROR":
 		logger.Level = logrus.ErrorLevel
 	default:
-		return fmt.Errorf("Неизвестный уровень лога, %s", cfg.LogLevel)
+		return fmt.Errorf("Неизвестный уровень лога, %s", level)
 	}
-	// Настройка формата времени
-	logger.Formatter = &logrus.TextFormatter{TimestampFormat: "2006-01-02 15:04:05"}
-	// Информационное сообщение
-	logger.Infoln("Уровень логирования", cfg.LogLevel)
-	// Ошибок не было
 	return nil
 }
 
@@ -2,19 +2,20 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"os/signal"
 	"syscall"
+	"ws_monitoring/alert"
+	"ws_monitoring/api"
+	"ws_monitoring/baseline"
 	"ws_monitoring/helper"
 	"ws_monitoring/log"
+	"ws_monitoring/slo"
 	"ws_monitoring/workmanager"
-	"os"
-)
-
-var (
 )
 
 var (
-	cfg              *helper.Config
+	cfg *helper.Config
 	//startTime		= time.Now().Round(time.Second)
 
 	//эти переменные заполняются линкером.
@@ -28,38 +29,74 @@ var (
 // Основная функция программы
 //----------------------------------------------------------------------------------------------------------------------
 func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Работа в режиме службы: периодические проверки до получения сигнала завершения
+//----------------------------------------------------------------------------------------------------------------------
+func runDaemon() int {
 	var err error
 
 	// Загрузка конфигурации
-	cfg, err = helper.ReloadConfig(helper.ConfigFileName)
+	cfg, err = helper.ReloadConfig(helper.ConfigFileName, true)
 	if err != nil {
-		if err != helper.ErrNotModified {
-			fmt.Errorf("Не удалось загрузить %s: %s", helper.ConfigFileName, err)
-			return
-		}
+		fmt.Fprintf(os.Stderr, "Не удалось загрузить %s: %s\n", helper.ConfigFileName, err)
+		return exitError
 	}
 
 	// Инициализация логгера
 	if err := log.InitLogger(cfg); err != nil {
-		fmt.Errorf(err.Error())
-		return
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
 	}
 
 	log.Infof("Версия: %s. Собрано %s", version, buildtime)
 	log.Debugf("Конфигурация: %#v", cfg)
+	for _, warning := range cfg.Warnings {
+		log.Errorf("main, %s", warning)
+	}
+
+	// Запуск диспетчера оповещений
+	alert.Startup(cfg)
+
+	// Запуск учёта SLO и обнаружения аномалий
+	slo.Startup(cfg)
+	baseline.Startup(cfg)
 
 	// Запуск рабочего цикла
-	workmanager.Startup(cfg)
+	workmanager.OnReload(alert.Reload)
+	workmanager.OnReload(slo.Reload)
+	workmanager.OnReload(baseline.Reload)
+	workmanager.OnReload(api.Reload)
+	helper.OnSecretsChanged(workmanager.Reload)
+	if err := workmanager.Startup(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Не удалось запустить рабочий цикл: %v\n", err)
+		return exitError
+	}
+
+	// Запуск HTTP API
+	api.Startup(cfg)
 
-	// Контроль завершения программы по Ctrl-C
+	// Контроль завершения программы по Ctrl-C, перезагрузка конфигурации по SIGHUP
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	signal.Notify(sigChan, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGHUP)
 	for {
 		select {
-		case <-sigChan:
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				log.Info("Получен SIGHUP, перезагрузка конфигурации")
+				workmanager.Reload()
+				continue
+			}
+			api.Shutdown()
 			workmanager.Shutdown()
-			return
+			slo.Shutdown()
+			baseline.Shutdown()
+			alert.Shutdown()
+			return exitOK
 		}
 	}
 }
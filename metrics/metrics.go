@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Типы метрик
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+// Тип - значение метрики с набором меток
+type series struct {
+	labels map[string]string
+	value  float64
+}
+
+// Тип - семейство метрик с одним именем
+type family struct {
+	help   string
+	typ    string
+	series map[string]*series
+}
+
+// registry - синглтон, хранит значения метрик для выдачи в формате Prometheus
+type registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+var r = registry{families: make(map[string]*family)}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Установка значения метрики-датчика
+//----------------------------------------------------------------------------------------------------------------------
+func SetGauge(name string, help string, labels map[string]string, value float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.series(name, help, typeGauge, labels).value = value
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Увеличение значения метрики-счётчика
+//----------------------------------------------------------------------------------------------------------------------
+func AddCounter(name string, help string, labels map[string]string, delta float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.series(name, help, typeCounter, labels).value += delta
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Удаление всех значений метрик, у которых метка name имеет значение value.
+// Используется при удалении сервиса из конфигурации.
+//----------------------------------------------------------------------------------------------------------------------
+func DeleteByLabel(name string, value string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, f := range r.families {
+		for key, s := range f.series {
+			if s.labels[name] == value {
+				delete(f.series, key)
+			}
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Удаление значений метрики name, у которых совпадают все указанные метки
+//----------------------------------------------------------------------------------------------------------------------
+func Delete(name string, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		return
+	}
+	for key, s := range f.series {
+		matched := true
+		for label, value := range labels {
+			if s.labels[label] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			delete(f.series, key)
+		}
+	}
+}
+
+func (r *registry) series(name string, help string, typ string, labels map[string]string) *series {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{help: help, typ: typ, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := labelsString(labels)
+	s, ok := f.series[key]
+	if !ok {
+		copied := make(map[string]string, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		s = &series{labels: copied}
+		f.series[key] = s
+	}
+	return s
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Выдача всех метрик в текстовом формате Prometheus
+//----------------------------------------------------------------------------------------------------------------------
+func Write(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.families[name]
+		if len(f.series) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := strconv.FormatFloat(f.series[key].value, 'g', -1, 64)
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", name, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func labelsString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,388 @@
+package slo
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+const (
+	stateFileName     = "slo.json"
+	defaultWindowDays = 30
+	minuteHistory     = 6 * time.Hour // поминутная история нужна только для коротких окон расхода бюджета
+	saveInterval      = time.Minute
+	alertName         = "ErrorBudgetBurn"
+)
+
+// Правила оповещения о скорости расхода бюджета ошибок: расход должен превышать порог
+// одновременно в длинном и коротком окне, чтобы не срабатывать на давно завершившиеся сбои.
+var burnRules = []struct {
+	long     time.Duration
+	short    time.Duration
+	factor   float64
+	severity string
+}{
+	{time.Hour, 5 * time.Minute, 14.4, alert.SeverityCritical},
+	{6 * time.Hour, 30 * time.Minute, 6, alert.SeverityWarning},
+}
+
+// Окна, по которым публикуется скорость расхода бюджета
+var burnWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+// Тип - счётчики проверок за интервал времени
+type bucket struct {
+	Start int64 `json:"t"` // unix-время начала интервала
+	Total int   `json:"n"` // всего проверок
+	Good  int   `json:"g"` // успешных проверок
+	Fast  int   `json:"f"` // успешных проверок быстрее порога
+}
+
+// Тип - история проверок сервиса: поминутная за последние часы и почасовая за всё окно SLO
+type history struct {
+	Minutes  []bucket  `json:"minutes"`
+	Hours    []bucket  `json:"hours"`
+	Burning  string    `json:"burning,omitempty"` // уровень активного оповещения о расходе бюджета
+	BurnedAt time.Time `json:"burned_at,omitempty"`
+	address  string
+	tags     map[string]string
+}
+
+// Report - состояние SLO сервиса
+type Report struct {
+	Service                     string             `json:"service"`
+	WindowDays                  int                `json:"window_days"`
+	Checks                      int                `json:"checks"`
+	AvailabilityObjective       float64            `json:"availability_objective"`
+	Availability                float64            `json:"availability"`
+	AvailabilityBudgetRemaining float64            `json:"availability_budget_remaining"`
+	LatencyThreshold            float64            `json:"latency_threshold,omitempty"`
+	LatencyObjective            float64            `json:"latency_objective,omitempty"`
+	LatencyGood                 float64            `json:"latency_good,omitempty"`
+	LatencyBudgetRemaining      float64            `json:"latency_budget_remaining,omitempty"`
+	BurnRates                   map[string]float64 `json:"burn_rates"`
+}
+
+// tracker - синглтон, накапливает историю проверок и вычисляет SLO
+type tracker struct {
+	mutex           sync.Mutex
+	stateDir        string
+	objectives      map[string]*helper.SLOConfig
+	services        map[string]*history
+	dirty           bool
+	ShutdownChannel chan string
+}
+
+var t tracker
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск учёта SLO, загрузка сохранённой истории
+//----------------------------------------------------------------------------------------------------------------------
+func Startup(cfg *helper.Config) {
+	log.Info("slo.Startup, Started")
+	t = tracker{
+		stateDir:        cfg.StateDir,
+		objectives:      objectives(cfg),
+		services:        make(map[string]*history),
+		ShutdownChannel: make(chan string),
+	}
+	if err := helper.LoadState(t.stateDir, stateFileName, &t.services); err != nil {
+		log.Errorf("slo.Startup, ошибка загрузки истории: %v", err)
+	}
+	if t.services == nil {
+		t.services = make(map[string]*history)
+	}
+	go t.loop()
+	log.Info("slo.Startup, Completed")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение новой конфигурации, история сервисов сохраняется
+//----------------------------------------------------------------------------------------------------------------------
+func Reload(cfg *helper.Config) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.objectives = objectives(cfg)
+	if t.stateDir != cfg.StateDir {
+		t.stateDir = cfg.StateDir
+		t.dirty = true
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка учёта SLO с сохранением истории
+//----------------------------------------------------------------------------------------------------------------------
+func Shutdown() {
+	log.Info("slo.Shutdown, Started")
+	t.ShutdownChannel <- "Down"
+	<-t.ShutdownChannel
+	close(t.ShutdownChannel)
+	t.mutex.Lock()
+	t.save()
+	t.mutex.Unlock()
+	log.Info("slo.Shutdown, Completed")
+}
+
+func objectives(cfg *helper.Config) map[string]*helper.SLOConfig {
+	list := make(map[string]*helper.SLOConfig)
+	for _, service := range cfg.Services {
+		if service.SLO != nil {
+			list[service.Name] = service.SLO
+		}
+	}
+	return list
+}
+
+func (t *tracker) loop() {
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ShutdownChannel:
+			t.ShutdownChannel <- "Down"
+			return
+		case <-ticker.C:
+			t.mutex.Lock()
+			if t.dirty {
+				t.save()
+			}
+			t.mutex.Unlock()
+		}
+	}
+}
+
+func (t *tracker) save() {
+	if err := helper.SaveState(t.stateDir, stateFileName, t.services); err != nil {
+		log.Errorf("slo.save, ошибка сохранения истории: %v", err)
+		return
+	}
+	t.dirty = false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Учёт результата проверки сервиса. Для сервисов без SLO ничего не делает.
+//----------------------------------------------------------------------------------------------------------------------
+func Record(service string, address string, tags map[string]string, checkTime time.Time, good bool, duration time.Duration) {
+	t.mutex.Lock()
+	objective, ok := t.objectives[service]
+	if !ok {
+		t.mutex.Unlock()
+		return
+	}
+	h, ok := t.services[service]
+	if !ok {
+		h = &history{}
+		t.services[service] = h
+	}
+	h.address, h.tags = address, tags
+	fast := good && (objective.Latency <= 0 || duration <= objective.Latency.Duration())
+	h.Minutes = add(h.Minutes, checkTime.Truncate(time.Minute).Unix(), good, fast)
+	h.Hours = add(h.Hours, checkTime.Truncate(time.Hour).Unix(), good, fast)
+	h.Minutes = trim(h.Minutes, checkTime.Add(-minuteHistory).Unix())
+	h.Hours = trim(h.Hours, checkTime.AddDate(0, 0, -windowDays(objective)).Unix())
+	t.dirty = true
+
+	report := h.report(service, objective, checkTime)
+	a := h.evaluate(service, objective, report, checkTime)
+	t.mutex.Unlock()
+
+	publish(report)
+	if a != nil {
+		alert.Notify(a)
+	}
+}
+
+func add(buckets []bucket, start int64, good bool, fast bool) []bucket {
+	if n := len(buckets); n == 0 || buckets[n-1].Start != start {
+		buckets = append(buckets, bucket{Start: start})
+	}
+	b := &buckets[len(buckets)-1]
+	b.Total++
+	if good {
+		b.Good++
+	}
+	if fast {
+		b.Fast++
+	}
+	return buckets
+}
+
+func trim(buckets []bucket, since int64) []bucket {
+	i := 0
+	for i < len(buckets) && buckets[i].Start < since {
+		i++
+	}
+	return buckets[i:]
+}
+
+func sum(buckets []bucket, since int64) (total bucket) {
+	for i := len(buckets) - 1; i >= 0 && buckets[i].Start >= since; i-- {
+		total.Total += buckets[i].Total
+		total.Good += buckets[i].Good
+		total.Fast += buckets[i].Fast
+	}
+	return total
+}
+
+func windowDays(objective *helper.SLOConfig) int {
+	if objective.WindowDays > 0 {
+		return objective.WindowDays
+	}
+	return defaultWindowDays
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Скорость расхода бюджета ошибок за окно: доля ошибок, делённая на допустимую долю ошибок.
+// Учитывается худший из показателей - доступность или время ответа.
+//----------------------------------------------------------------------------------------------------------------------
+func (h *history) burnRate(objective *helper.SLOConfig, window time.Duration, now time.Time) float64 {
+	total := sum(h.Minutes, now.Add(-window).Truncate(time.Minute).Unix())
+	rate := 0.0
+	if total.Total > 0 && objective.Availability > 0 && objective.Availability < 100 {
+		rate = float64(total.Total-total.Good) / float64(total.Total) / (1 - objective.Availability/100)
+	}
+	if total.Good > 0 && objective.Latency > 0 && objective.LatencyObjective > 0 && objective.LatencyObjective < 100 {
+		latencyRate := float64(total.Good-total.Fast) / float64(total.Good) / (1 - objective.LatencyObjective/100)
+		if latencyRate > rate {
+			rate = latencyRate
+		}
+	}
+	return rate
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Вычисление показателей SLO по истории
+//----------------------------------------------------------------------------------------------------------------------
+func (h *history) report(service string, objective *helper.SLOConfig, now time.Time) Report {
+	days := windowDays(objective)
+	total := sum(h.Hours, now.AddDate(0, 0, -days).Unix())
+	report := Report{
+		Service:                     service,
+		WindowDays:                  days,
+		Checks:                      total.Total,
+		AvailabilityObjective:       objective.Availability,
+		Availability:                100,
+		AvailabilityBudgetRemaining: 1,
+		LatencyThreshold:            objective.Latency.Seconds(),
+		LatencyObjective:            objective.LatencyObjective,
+		BurnRates:                   make(map[string]float64, len(burnWindows)),
+	}
+	if total.Total > 0 {
+		report.Availability = 100 * float64(total.Good) / float64(total.Total)
+		report.AvailabilityBudgetRemaining = budgetRemaining(report.Availability, objective.Availability)
+	}
+	if objective.Latency > 0 && objective.LatencyObjective > 0 {
+		report.LatencyGood = 100
+		report.LatencyBudgetRemaining = 1
+		if total.Good > 0 {
+			report.LatencyGood = 100 * float64(total.Fast) / float64(total.Good)
+			report.LatencyBudgetRemaining = budgetRemaining(report.LatencyGood, objective.LatencyObjective)
+		}
+	}
+	for _, window := range burnWindows {
+		report.BurnRates[formatWindow(window)] = h.burnRate(objective, window, now)
+	}
+	return report
+}
+
+// Остаток бюджета ошибок: 1 - бюджет не израсходован, 0 - израсходован полностью, меньше 0 - превышен
+func budgetRemaining(actual float64, objective float64) float64 {
+	if objective <= 0 || objective >= 100 {
+		return 1
+	}
+	return 1 - (100-actual)/(100-objective)
+}
+
+func formatWindow(window time.Duration) string {
+	if window < time.Hour {
+		return fmt.Sprintf("%dm", int(window.Minutes()))
+	}
+	return fmt.Sprintf("%dh", int(window.Hours()))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка правил расхода бюджета. Возвращает оповещение, если уровень изменился.
+//----------------------------------------------------------------------------------------------------------------------
+func (h *history) evaluate(service string, objective *helper.SLOConfig, report Report, now time.Time) *alert.Alert {
+	severity := ""
+	summary := ""
+	for _, rule := range burnRules {
+		long := report.BurnRates[formatWindow(rule.long)]
+		short := report.BurnRates[formatWindow(rule.short)]
+		if long > rule.factor && short > rule.factor {
+			severity = rule.severity
+			summary = fmt.Sprintf("Бюджет ошибок расходуется в %.1f раз быстрее допустимого (окно %s), остаток доступности %.1f%%",
+				long, formatWindow(rule.long), 100*report.AvailabilityBudgetRemaining)
+			break
+		}
+	}
+	if severity == h.Burning {
+		return nil
+	}
+	a := &alert.Alert{
+		Name:     alertName,
+		Service:  service,
+		Address:  h.address,
+		Tags:     h.tags,
+		Severity: severity,
+		Summary:  summary,
+	}
+	if severity == "" {
+		a.Status = alert.StatusResolved
+		a.Severity = h.Burning
+		a.StartsAt = h.BurnedAt
+		a.EndsAt = now
+		a.Summary = "Расход бюджета ошибок вернулся в норму"
+	} else {
+		if h.Burning == "" {
+			h.BurnedAt = now
+		}
+		a.Status = alert.StatusFiring
+		a.StartsAt = h.BurnedAt
+	}
+	log.Infof("slo.evaluate, сервис %s, расход бюджета ошибок: %q -> %q", service, h.Burning, severity)
+	h.Burning = severity
+	return a
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Публикация показателей SLO в метриках
+//----------------------------------------------------------------------------------------------------------------------
+func publish(report Report) {
+	labels := map[string]string{"service": report.Service}
+	metrics.SetGauge("ws_slo_availability_ratio", "Доступность сервиса за окно SLO", labels, report.Availability/100)
+	metrics.SetGauge("ws_slo_availability_objective_ratio", "Цель доступности сервиса", labels, report.AvailabilityObjective/100)
+	metrics.SetGauge("ws_slo_error_budget_remaining_ratio", "Остаток бюджета ошибок",
+		map[string]string{"service": report.Service, "objective": "availability"}, report.AvailabilityBudgetRemaining)
+	if report.LatencyObjective > 0 {
+		metrics.SetGauge("ws_slo_error_budget_remaining_ratio", "Остаток бюджета ошибок",
+			map[string]string{"service": report.Service, "objective": "latency"}, report.LatencyBudgetRemaining)
+	}
+	for window, rate := range report.BurnRates {
+		metrics.SetGauge("ws_slo_burn_rate", "Скорость расхода бюджета ошибок",
+			map[string]string{"service": report.Service, "window": window}, rate)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Показатели SLO всех сервисов, для которых они заданы
+//----------------------------------------------------------------------------------------------------------------------
+func Reports() []Report {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	list := make([]Report, 0, len(t.objectives))
+	for service, objective := range t.objectives {
+		h, ok := t.services[service]
+		if !ok {
+			h = &history{}
+		}
+		list = append(list, h.report(service, objective, now))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Service < list[j].Service })
+	return list
+}
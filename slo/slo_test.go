@@ -0,0 +1,131 @@
+package slo
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitConsoleLogger(ioutil.Discard, "error")
+	os.Exit(m.Run())
+}
+
+var sloStart = time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+// История из проверок раз в минуту за 6 часов: bad(i) - проверка в минуту i неуспешна, slow(i) - медленная
+func testHistory(bad func(i int) bool, slow func(i int) bool) *history {
+	h := &history{}
+	for i := 0; i < 360; i++ {
+		checkTime := sloStart.Add(time.Duration(i) * time.Minute)
+		good := !bad(i)
+		fast := good && !slow(i)
+		h.Minutes = add(h.Minutes, checkTime.Truncate(time.Minute).Unix(), good, fast)
+		h.Hours = add(h.Hours, checkTime.Truncate(time.Hour).Unix(), good, fast)
+	}
+	return h
+}
+
+func between(from, to int) func(i int) bool {
+	return func(i int) bool { return i >= from && i <= to }
+}
+
+func never(int) bool { return false }
+
+func TestBurnRateWindows(t *testing.T) {
+	// Последняя проверка - в минуту 359. Окно включает начальную минуту: 5m - минуты 354..359 (6 проверок),
+	// 30m - 329..359 (31), 1h - 299..359 (61), 6h - все 360 проверок.
+	now := sloStart.Add(359*time.Minute + 30*time.Second)
+	availability := &helper.SLOConfig{Availability: 99}
+	latency := &helper.SLOConfig{Availability: 99, Latency: helper.Duration(time.Second), LatencyObjective: 90}
+	tests := []struct {
+		name      string
+		objective *helper.SLOConfig
+		h         *history
+		want      map[string]float64
+		severity  string
+	}{
+		{"без ошибок", availability, testHistory(never, never),
+			map[string]float64{"5m": 0, "30m": 0, "1h": 0, "6h": 0}, ""},
+		{"сбой в последние 6 минут", availability, testHistory(between(354, 359), never),
+			map[string]float64{"5m": 100, "30m": 600.0 / 31, "1h": 600.0 / 61, "6h": 600.0 / 360}, ""},
+		{"сбой в последний час", availability, testHistory(between(300, 359), never),
+			map[string]float64{"5m": 100, "30m": 100, "1h": 6000.0 / 61, "6h": 6000.0 / 360}, alert.SeverityCritical},
+		{"сбой завершился 30 минут назад", availability, testHistory(between(300, 328), never),
+			map[string]float64{"5m": 0, "30m": 0, "1h": 2900.0 / 61, "6h": 2900.0 / 360}, ""},
+		{"сбой завершился 10 минут назад", availability, testHistory(between(310, 349), never),
+			map[string]float64{"5m": 0, "30m": 2100.0 / 31, "1h": 4000.0 / 61, "6h": 4000.0 / 360}, alert.SeverityWarning},
+		{"медленные ответы", latency, testHistory(never, between(354, 359)),
+			map[string]float64{"5m": 10, "30m": 60.0 / 31, "1h": 60.0 / 61, "6h": 60.0 / 360}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := test.h.report("api", test.objective, now)
+			for window, want := range test.want {
+				if got := report.BurnRates[window]; math.Abs(got-want) > 1e-9 {
+					t.Errorf("окно %s: расход %.4f, ожидается %.4f", window, got, want)
+				}
+			}
+			a := test.h.evaluate("api", test.objective, report, now)
+			switch {
+			case test.severity == "" && a != nil:
+				t.Errorf("оповещение %s %s, не ожидается", a.Status, a.Severity)
+			case test.severity != "" && (a == nil || a.Status != alert.StatusFiring || a.Severity != test.severity):
+				t.Errorf("оповещение %+v, ожидается %s", a, test.severity)
+			}
+		})
+	}
+}
+
+func TestEvaluateTransitions(t *testing.T) {
+	objective := &helper.SLOConfig{Availability: 99}
+	h := &history{}
+	fire := func(rates map[string]float64, now time.Time) *alert.Alert {
+		return h.evaluate("api", objective, Report{BurnRates: rates, AvailabilityBudgetRemaining: 0.5}, now)
+	}
+	critical := map[string]float64{"5m": 20, "1h": 20, "30m": 20, "6h": 20}
+	warning := map[string]float64{"5m": 10, "1h": 10, "30m": 10, "6h": 10}
+	normal := map[string]float64{}
+
+	t1 := sloStart
+	if a := fire(critical, t1); a == nil || a.Status != alert.StatusFiring || a.Severity != alert.SeverityCritical {
+		t.Fatalf("оповещение %+v, ожидается critical", a)
+	}
+	if a := fire(critical, t1.Add(time.Minute)); a != nil {
+		t.Errorf("повторное оповещение без смены уровня: %+v", a)
+	}
+	a := fire(warning, t1.Add(2*time.Minute))
+	if a == nil || a.Severity != alert.SeverityWarning || !a.StartsAt.Equal(t1) {
+		t.Errorf("оповещение %+v, ожидается warning с началом %v", a, t1)
+	}
+	a = fire(normal, t1.Add(3*time.Minute))
+	if a == nil || a.Status != alert.StatusResolved || a.Severity != alert.SeverityWarning || !a.StartsAt.Equal(t1) {
+		t.Errorf("оповещение %+v, ожидается отбой warning", a)
+	}
+	if h.Burning != "" {
+		t.Errorf("уровень %q после отбоя", h.Burning)
+	}
+}
+
+func TestBudgetRemaining(t *testing.T) {
+	tests := []struct {
+		actual, objective, want float64
+	}{
+		{100, 99.9, 1},
+		{99.95, 99.9, 0.5},
+		{99.9, 99.9, 0},
+		{99.8, 99.9, -1},
+		{50, 0, 1},
+		{50, 100, 1},
+	}
+	for _, test := range tests {
+		if got := budgetRemaining(test.actual, test.objective); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("budgetRemaining(%v, %v) = %v, ожидается %v", test.actual, test.objective, got, test.want)
+		}
+	}
+}
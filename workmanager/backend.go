@@ -0,0 +1,160 @@
+package workmanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+const (
+	backendResolveTimeout = 10 * time.Second
+	backendDialTimeout    = 30 * time.Second
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка сервиса. При per_backend проверяется каждый адрес имени узла по отдельности.
+//----------------------------------------------------------------------------------------------------------------------
+func (worker *Worker) check(ctx context.Context) *CheckResult {
+	if !worker.Service.PerBackend {
+		return check(worker.URL, worker.Req.WithContext(ctx), &http.Client{})
+	}
+	return checkBackends(ctx, worker.URL, worker.Req)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка всех узлов за балансировщиком: имя узла из адреса разрешается во все записи A/AAAA, запрос
+// отправляется на каждый адрес. Заголовок Host и имя для TLS (SNI) остаются из адреса сервиса.
+// Итоговый результат содержит результаты узлов в Backends, уровень важности определяет evaluate.
+//----------------------------------------------------------------------------------------------------------------------
+func checkBackends(ctx context.Context, address string, req *http.Request) *CheckResult {
+	checkTime := time.Now()
+	result := &CheckResult{CheckTime: checkTime.Format(time.RFC3339), Address: address}
+
+	resolveCtx, cancel := context.WithTimeout(ctx, backendResolveTimeout)
+	ips, err := net.DefaultResolver.LookupIPAddr(resolveCtx, req.URL.Hostname())
+	cancel()
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("нет адресов для %s", req.URL.Hostname())
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.CheckDuration = time.Since(checkTime)
+		log.Errorf("Ошибка разрешения имени %s: %v", req.URL.Hostname(), err)
+		return result
+	}
+
+	result.Backends = make([]*CheckResult, len(ips))
+	panics := make([]error, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			defer CatchPanic(&panics[i], "checkWebService", "узел "+ip)
+			backend := check(address, req.Clone(ctx), backendClient(ip))
+			backend.Backend = ip
+			result.Backends[i] = backend
+		}(i, ip.IP.String())
+	}
+	wg.Wait()
+	// Паника при проверке узла передаётся в проверку сервиса, которой управляет супервизор
+	for i, err := range panics {
+		if err != nil {
+			panic(fmt.Sprintf("узел %s: %v", ips[i].IP, err))
+		}
+	}
+	sort.Slice(result.Backends, func(i, j int) bool { return result.Backends[i].Backend < result.Backends[j].Backend })
+	return result
+}
+
+// HTTP-клиент, который подключается к указанному адресу независимо от имени узла в запросе
+func backendClient(ip string) *http.Client {
+	dialer := &net.Dialer{Timeout: backendDialTimeout}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				_, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+			DisableKeepAlives:   true,
+		},
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Итоговый уровень по результатам узлов. Если отвечает хотя бы один узел, в том числе медленно или с уровнем
+// WARNING, отказ остальных - WARNING: сервис доступен через балансировщик, но с меньшим запасом.
+// Отказ всех узлов - CRITICAL.
+//----------------------------------------------------------------------------------------------------------------------
+func evaluateBackends(worker *Worker, checkResult *CheckResult) {
+	checkResult.Severity = SeverityOK
+	var worst *CheckResult
+	var failed []string
+	responding := 0
+	for _, backend := range checkResult.Backends {
+		evaluate(worker, backend)
+		if backend.Severity != SeverityCritical {
+			responding++
+		}
+		if backend.Severity != SeverityOK {
+			failed = append(failed, backend.Backend)
+		}
+		if worst == nil || severityLevel(backend.Severity) > severityLevel(worst.Severity) ||
+			backend.CheckDuration > worst.CheckDuration && backend.Severity == worst.Severity {
+			worst = backend
+		}
+	}
+	checkResult.StatusCode = worst.StatusCode
+	checkResult.CheckDuration = worst.CheckDuration
+	checkResult.Error = worst.Error
+	checkResult.Severity = worst.Severity
+	if worst.Severity == SeverityOK {
+		return
+	}
+	if worst.Severity == SeverityCritical && responding > 0 {
+		checkResult.Severity = SeverityWarning
+	}
+	checkResult.Reason = fmt.Sprintf("Узлы с ошибками %d из %d (%s). Узел %s: %s", len(failed), len(checkResult.Backends),
+		strings.Join(failed, ", "), worst.Backend, worst.Reason)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Метрики узлов с меткой backend. Метрики узлов, которых больше нет в DNS, удаляются.
+//----------------------------------------------------------------------------------------------------------------------
+func recordBackends(worker *Worker, checkResult *CheckResult) {
+	current := make(map[string]bool, len(checkResult.Backends))
+	for _, backend := range checkResult.Backends {
+		current[backend.Backend] = true
+		labels := map[string]string{"service": worker.Name, "backend": backend.Backend}
+		up := 0.0
+		if backend.Severity != SeverityCritical {
+			up = 1
+		}
+		metrics.SetGauge("ws_backend_up", "Результат последней проверки узла сервиса: 1 - доступен", labels, up)
+		metrics.SetGauge("ws_backend_duration_seconds", "Длительность последней проверки узла сервиса", labels,
+			backend.CheckDuration.Seconds())
+	}
+	// При ошибке разрешения имени прежний список узлов сохраняется
+	if checkResult.Backends == nil {
+		return
+	}
+	for backend := range worker.backends {
+		if !current[backend] {
+			for _, name := range []string{"ws_backend_up", "ws_backend_duration_seconds"} {
+				metrics.Delete(name, map[string]string{"service": worker.Name, "backend": backend})
+			}
+		}
+	}
+	worker.backends = current
+}
@@ -0,0 +1,42 @@
+package workmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateBackends(t *testing.T) {
+	ok := func(ip string) *CheckResult {
+		return &CheckResult{Backend: ip, StatusCode: 200, CheckDuration: 100 * time.Millisecond}
+	}
+	slow := func(ip string) *CheckResult {
+		return &CheckResult{Backend: ip, StatusCode: 200, CheckDuration: 2 * time.Second}
+	}
+	down := func(ip string) *CheckResult {
+		return &CheckResult{Backend: ip, Error: "connection refused"}
+	}
+	tests := []struct {
+		name     string
+		backends []*CheckResult
+		want     string
+		failed   bool // в причине перечислены узлы с ошибками
+	}{
+		{"все узлы отвечают", []*CheckResult{ok("10.0.0.1"), ok("10.0.0.2")}, SeverityOK, false},
+		{"один узел медленный", []*CheckResult{ok("10.0.0.1"), slow("10.0.0.2")}, SeverityWarning, true},
+		{"один узел недоступен", []*CheckResult{ok("10.0.0.1"), down("10.0.0.2")}, SeverityWarning, true},
+		{"медленный и недоступный узлы", []*CheckResult{slow("10.0.0.1"), down("10.0.0.2")}, SeverityWarning, true},
+		{"все узлы недоступны", []*CheckResult{down("10.0.0.1"), down("10.0.0.2")}, SeverityCritical, true},
+	}
+	for _, test := range tests {
+		worker := testWorker("api", time.Minute)
+		worker.WarnLatency, worker.CritLatency = time.Second, 5*time.Second
+		checkResult := &CheckResult{Backends: test.backends}
+		evaluate(worker, checkResult)
+		if checkResult.Severity != test.want {
+			t.Errorf("%s: уровень %s, ожидается %s (%s)", test.name, checkResult.Severity, test.want, checkResult.Reason)
+		}
+		if (checkResult.Reason != "") != test.failed {
+			t.Errorf("%s: причина %q", test.name, checkResult.Reason)
+		}
+	}
+}
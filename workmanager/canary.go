@@ -0,0 +1,192 @@
+package workmanager
+
+import (
+	"net"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+// Интервал сетевой проверки по умолчанию
+const defaultCanaryInterval = 10 * time.Second
+
+// Тип - сетевая проверка, от которой зависят сервисы
+type canary struct {
+	Name        string
+	Address     string
+	Interval    time.Duration
+	Up          bool
+	Checked     bool
+	DownSince   time.Time
+	StopChannel chan bool
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск сетевых проверок
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) InitCanaries(cfg *helper.Config) {
+	workManager.mutex.Lock()
+	defer workManager.mutex.Unlock()
+	workManager.canaries = make(map[string]*canary, len(cfg.Canaries))
+	for _, c := range cfg.Canaries {
+		item := newCanary(c)
+		workManager.canaries[c.Name] = item
+		go workManager.checkCanary(item)
+	}
+}
+
+func newCanary(c helper.Canary) *canary {
+	interval := c.CheckInterval.Duration()
+	if interval <= 0 {
+		interval = defaultCanaryInterval
+	}
+	return &canary{
+		Name:        c.Name,
+		Address:     c.Address,
+		Interval:    interval,
+		StopChannel: make(chan bool),
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение нового списка сетевых проверок. Неизменённые проверки продолжают работу, изменённые
+// перезапускаются с сохранением последнего результата, удалённые останавливаются с отбоем оповещения.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) ReloadCanaries(cfg *helper.Config) {
+	workManager.mutex.Lock()
+	current := workManager.canaries
+	canaries := make(map[string]*canary, len(cfg.Canaries))
+	var started, stopped []*canary
+	for _, c := range cfg.Canaries {
+		item := newCanary(c)
+		old, ok := current[c.Name]
+		delete(current, c.Name)
+		if ok && old.Address == item.Address && old.Interval == item.Interval {
+			canaries[c.Name] = old
+			continue
+		}
+		if ok {
+			log.Infof("workingLoop, изменены настройки сетевой проверки %s, перезапуск", c.Name)
+			item.Up, item.Checked, item.DownSince = old.Up, old.Checked, old.DownSince
+			stopped = append(stopped, old)
+		} else {
+			log.Infof("workingLoop, добавлена сетевая проверка %s", c.Name)
+		}
+		canaries[c.Name] = item
+		started = append(started, item)
+	}
+	removed := make([]*canary, 0, len(current))
+	for _, old := range current {
+		log.Infof("workingLoop, удалена сетевая проверка %s", old.Name)
+		removed = append(removed, old)
+	}
+	workManager.canaries = canaries
+	workManager.mutex.Unlock()
+
+	for _, item := range append(stopped, removed...) {
+		close(item.StopChannel)
+	}
+	for _, item := range removed {
+		alert.Notify(&alert.Alert{
+			Name:     alert.AlertCanaryDown,
+			Service:  item.Name,
+			Address:  item.Address,
+			Severity: alert.SeverityCritical,
+			Status:   alert.StatusResolved,
+			StartsAt: item.DownSince,
+			EndsAt:   time.Now(),
+			Summary:  "Сетевая проверка удалена из конфигурации",
+		})
+	}
+	for _, item := range started {
+		go workManager.checkCanary(item)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка сетевых проверок
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) CloseCanaries() {
+	workManager.mutex.Lock()
+	canaries := workManager.canaries
+	workManager.canaries = nil
+	workManager.mutex.Unlock()
+	for _, item := range canaries {
+		close(item.StopChannel)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Рабочий цикл сетевой проверки: TCP-подключение к указанному адресу
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) checkCanary(item *canary) {
+	log.Debugf("checkCanary [%s], запущена сетевая проверка %s", item.Name, item.Address)
+	for {
+		conn, err := net.DialTimeout("tcp", item.Address, item.Interval)
+		if err == nil {
+			conn.Close()
+		}
+		up := err == nil
+
+		workManager.mutex.Lock()
+		previous, checked := item.Up, item.Checked
+		item.Up, item.Checked = up, true
+		if !up && (previous || !checked) {
+			item.DownSince = time.Now()
+		}
+		downSince := item.DownSince
+		workManager.mutex.Unlock()
+
+		if !checked || previous != up {
+			a := &alert.Alert{
+				Name:     alert.AlertCanaryDown,
+				Service:  item.Name,
+				Address:  item.Address,
+				Severity: alert.SeverityCritical,
+				StartsAt: downSince,
+			}
+			if up {
+				a.Status = alert.StatusResolved
+				a.EndsAt = time.Now()
+				a.Summary = "Сетевая проверка снова успешна"
+				log.Infof("checkCanary [%s], сетевая проверка успешна", item.Name)
+			} else {
+				a.Status = alert.StatusFiring
+				a.Summary = "Сетевая проверка не прошла: " + err.Error()
+				log.Errorf("checkCanary [%s], сетевая проверка не прошла: %v", item.Name, err)
+			}
+			alert.Notify(a)
+		}
+
+		select {
+		case <-item.StopChannel:
+			log.Debugf("checkCanary [%s], выключение сетевой проверки", item.Name)
+			return
+		case <-time.After(item.Interval):
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка доступности зависимостей сервиса.
+// Возвращает имя первой недоступной зависимости: сервиса в состоянии down или unreachable
+// либо не прошедшей сетевой проверки. Вызывается под блокировкой workManager.mutex.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) failedDependency(worker *Worker) string {
+	for _, name := range worker.DependsOn {
+		if item, ok := workManager.canaries[name]; ok {
+			if item.Checked && !item.Up {
+				return name
+			}
+			continue
+		}
+		for _, parent := range workManager.Workers {
+			if parent.Name == name && parent.State &&
+				(parent.ServiceState == ServiceStateDown || parent.ServiceState == ServiceStateUnreachable) {
+				return name
+			}
+		}
+	}
+	return ""
+}
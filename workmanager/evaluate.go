@@ -0,0 +1,92 @@
+package workmanager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"ws_monitoring/helper"
+)
+
+// Уровни важности результата проверки
+const (
+	SeverityOK       = "OK"
+	SeverityWarning  = "WARNING"
+	SeverityCritical = "CRITICAL"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+// Определение уровня важности результата проверки по коду ответа и времени ответа.
+// По умолчанию коды 2xx и 3xx - OK, остальные - CRITICAL. Правила status_codes уточняют это:
+// точный код имеет приоритет над классом. Итоговый уровень - худший из уровня по коду и по времени ответа.
+//----------------------------------------------------------------------------------------------------------------------
+func evaluate(worker *Worker, checkResult *CheckResult) {
+	if checkResult.Backends != nil {
+		evaluateBackends(worker, checkResult)
+		return
+	}
+	if checkResult.Error != "" {
+		checkResult.Severity = SeverityCritical
+		checkResult.Reason = fmt.Sprintf("Сервис недоступен: %s", checkResult.Error)
+		return
+	}
+
+	checkResult.Severity = statusSeverity(worker.StatusCodes, checkResult.StatusCode)
+	if checkResult.Severity != SeverityOK {
+		checkResult.Reason = fmt.Sprintf("Сервис вернул код %d", checkResult.StatusCode)
+	}
+
+	latency := SeverityOK
+	threshold := time.Duration(0)
+	if worker.CritLatency > 0 && checkResult.CheckDuration >= worker.CritLatency {
+		latency, threshold = SeverityCritical, worker.CritLatency
+	} else if worker.WarnLatency > 0 && checkResult.CheckDuration >= worker.WarnLatency {
+		latency, threshold = SeverityWarning, worker.WarnLatency
+	}
+	if severityLevel(latency) > severityLevel(checkResult.Severity) {
+		checkResult.Severity = latency
+		checkResult.Reason = fmt.Sprintf("Время ответа %.3f с превышает порог %.3f с",
+			checkResult.CheckDuration.Seconds(), threshold.Seconds())
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Однократная проверка сервиса. Результат не учитывается в состоянии сервиса, метриках и оповещениях.
+//----------------------------------------------------------------------------------------------------------------------
+func CheckOnce(service helper.Service) *CheckResult {
+	worker := newWorker(service)
+	checkResult := worker.check(context.Background())
+	evaluate(worker, checkResult)
+	return checkResult
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Уровень важности по коду ответа
+//----------------------------------------------------------------------------------------------------------------------
+func statusSeverity(rules map[string]string, code int) string {
+	exact := strconv.Itoa(code)
+	class := fmt.Sprintf("%dxx", code/100)
+	for _, key := range []string{exact, class} {
+		for pattern, severity := range rules {
+			if strings.EqualFold(pattern, key) {
+				return strings.ToUpper(severity)
+			}
+		}
+	}
+	if code >= 200 && code < 400 {
+		return SeverityOK
+	}
+	return SeverityCritical
+}
+
+// Числовой уровень важности для сравнения
+func severityLevel(severity string) int {
+	switch severity {
+	case SeverityWarning:
+		return 1
+	case SeverityCritical:
+		return 2
+	}
+	return 0
+}
@@ -2,21 +2,23 @@ package workmanager
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	//"errors"
 	"io"
 	//"io/ioutil"
 	"net/http"
+	"ws_monitoring/helper"
 )
 
 //var _ = log.Print
 
-func makeRequest(method string, url string, entity interface{}) (*http.Response, error) {
+func makeRequest(ctx context.Context, method string, url string, entity interface{}) (*http.Response, error) {
 	req, err := buildRequest(method, url, entity)
 	if err != nil {
 		return nil, err
 	}
-	return http.DefaultClient.Do(req)
+	return http.DefaultClient.Do(req.WithContext(ctx))
 }
 
 func buildRequest(method string, url string, entity interface{}) (*http.Request, error) {
@@ -40,7 +42,8 @@ func encodeEntity(entity interface{}) (io.Reader, error) {
 		if err != nil {
 			return nil, err
 		}
-		return bytes.NewBuffer(b), nil
+		// Результаты проверок не должны передавать сборщику данных значения секретов
+		return bytes.NewBuffer(helper.RedactSecretsBytes(b)), nil
 	}
 }
 
@@ -0,0 +1,174 @@
+package workmanager
+
+import (
+	"reflect"
+	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+// ConfigStatus - состояние загрузки конфигурации для HTTP API
+type ConfigStatus struct {
+	File          string     `json:"file"`
+	Files         []string   `json:"files"`                     // основной и подключённые файлы
+	Warnings      []string   `json:"warnings,omitempty"`        // источники по HTTP, вместо которых использованы копии
+	Checksum      string     `json:"checksum"`                  // SHA-256 содержимого действующей конфигурации
+	LoadedAt      time.Time  `json:"loaded_at"`                 // время применения действующей конфигурации
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"` // время последней попытки перезагрузки, nil до первой
+	LastError     string     `json:"last_error,omitempty"`      // ошибка последней попытки, пусто при успехе
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`   // время последней ошибки, nil если ошибок не было
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Результат последней загрузки конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func Configuration() ConfigStatus {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+	return wm.config
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отметка об успешном применении конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) configApplied(cfg *helper.Config, now time.Time) {
+	workManager.mutex.Lock()
+	workManager.config.File = helper.ConfigFileName
+	workManager.config.Files = cfg.Files
+	workManager.config.Warnings = cfg.Warnings
+	workManager.config.Checksum = cfg.Checksum
+	workManager.config.LoadedAt = now
+	workManager.config.LastAttemptAt = &now
+	workManager.config.LastError = ""
+	workManager.mutex.Unlock()
+
+	metrics.SetGauge("ws_config_last_reload_successful", "Результат последней загрузки конфигурации: 1 - успешно",
+		nil, 1)
+	metrics.SetGauge("ws_config_last_reload_success_timestamp_seconds", "Время последней успешной загрузки конфигурации",
+		nil, float64(now.Unix()))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запрос немедленной перезагрузки конфигурации, например по сигналу SIGHUP
+//----------------------------------------------------------------------------------------------------------------------
+func Reload() {
+	select {
+	case wm.ReloadChannel <- true:
+	default:
+		// Перезагрузка уже запрошена
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Перезагрузка конфигурации, если файл изменился или force. Возвращает новую конфигурацию или nil, если файл
+// не изменился или новая конфигурация отклонена. При ошибке продолжается работа с последней корректной
+// конфигурацией, повторная попытка выполняется после следующего изменения файла.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) reloadConfig(force bool) *helper.Config {
+	cfg, err := helper.ReloadConfig(helper.ConfigFileName, force)
+	if err == helper.ErrNotModified {
+		log.Debugf("workingLoop, конфигурация не изменилась")
+		return nil
+	}
+	if err == nil {
+		err = log.InitLogger(cfg)
+	}
+	now := time.Now()
+	if err != nil {
+		log.Errorf("workingLoop, конфигурация %s (контрольная сумма %s) отклонена, работа продолжается с предыдущей: %v",
+			helper.ConfigFileName, helper.ConfigChecksum(), err)
+		workManager.mutex.Lock()
+		workManager.config.LastAttemptAt = &now
+		workManager.config.LastError = err.Error()
+		workManager.config.LastErrorAt = &now
+		workManager.mutex.Unlock()
+
+		metrics.SetGauge("ws_config_last_reload_successful", "Результат последней загрузки конфигурации: 1 - успешно",
+			nil, 0)
+		metrics.AddCounter("ws_config_reload_failures_total", "Число отклонённых перезагрузок конфигурации", nil, 1)
+		return nil
+	}
+
+	log.Infof("Перезагружен конфигурационный файл %s, контрольная сумма %s", helper.ConfigFileName, cfg.Checksum)
+	for _, warning := range cfg.Warnings {
+		log.Errorf("workingLoop, %s", warning)
+	}
+	workManager.configApplied(cfg, now)
+	return cfg
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Применение нового списка сервисов. Сервисы сопоставляются по имени: добавленные запускаются, удалённые
+// останавливаются, изменённые перезапускаются с сохранением состояния сервиса. Рабочие потоки неизменённых
+// сервисов продолжают работу со своим расписанием и состоянием.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) ReloadWorkers(cfg *helper.Config) {
+	workManager.mutex.RLock()
+	current := make(map[string]*Worker, len(workManager.Workers))
+	for _, worker := range workManager.Workers {
+		current[worker.Name] = worker
+	}
+	workManager.mutex.RUnlock()
+
+	workers := make(WorkersList, 0, len(cfg.Services))
+	var started WorkersList
+	for _, service := range cfg.Services {
+		old, ok := current[service.Name]
+		delete(current, service.Name)
+		if ok && reflect.DeepEqual(old.Service, service) {
+			workers = append(workers, old)
+			continue
+		}
+		worker := newWorker(service)
+		if ok {
+			log.Infof("workingLoop, изменены настройки сервиса %s, перезапуск рабочего потока", service.Name)
+			workManager.stopWorker(old)
+			// Рабочий поток остановлен, его состояние больше никто не изменяет
+			worker.inheritState(old)
+		} else {
+			log.Infof("workingLoop, добавлен сервис %s", service.Name)
+		}
+		workers = append(workers, worker)
+		started = append(started, worker)
+	}
+	for name, old := range current {
+		log.Infof("workingLoop, удалён сервис %s", name)
+		workManager.stopWorker(old)
+		workManager.forgetService(old)
+	}
+
+	workManager.mutex.Lock()
+	workManager.collectorURL = cfg.DataCollectorURL
+	workManager.Workers = workers
+	workManager.mutex.Unlock()
+
+	for _, worker := range started {
+		workManager.startWorker(worker)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Отбой оповещений и удаление метрик сервиса, удалённого из конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) forgetService(worker *Worker) {
+	metrics.DeleteByLabel("service", worker.Name)
+	severities := map[string]string{
+		alert.AlertServiceDown:    alert.SeverityCritical,
+		alert.AlertServiceWarning: alert.SeverityWarning,
+	}
+	for name, severity := range severities {
+		alert.Notify(&alert.Alert{
+			Name:     name,
+			Service:  worker.Name,
+			Address:  worker.URL,
+			Severity: severity,
+			Tags:     worker.Tags,
+			Status:   alert.StatusResolved,
+			EndsAt:   time.Now(),
+			StartsAt: worker.StateSince,
+			Summary:  "Сервис удалён из конфигурации",
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package workmanager
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+)
+
+// Время попытки и ошибки не выводится, пока перезагрузки и ошибки не было
+func TestConfigStatusJSON(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	m := &workManager{}
+	encode := func() string {
+		data, err := json.Marshal(m.config)
+		if err != nil {
+			t.Fatalf("ошибка кодирования: %v", err)
+		}
+		return string(data)
+	}
+	tests := []struct {
+		name    string
+		apply   func()
+		present []string
+		absent  []string
+	}{
+		{"до загрузки", func() {}, nil, []string{"last_attempt_at", "last_error_at"}},
+		{"успешная загрузка", func() { m.configApplied(&helper.Config{}, now) },
+			[]string{`"last_attempt_at":"2024-03-04T12:00:00Z"`}, []string{"last_error_at"}},
+		{"ошибка загрузки", func() {
+			later := now.Add(time.Minute)
+			m.config.LastAttemptAt, m.config.LastErrorAt = &later, &later
+		}, []string{`"last_attempt_at":"2024-03-04T12:01:00Z"`, `"last_error_at":"2024-03-04T12:01:00Z"`}, nil},
+	}
+	for _, test := range tests {
+		test.apply()
+		got := encode()
+		for _, field := range test.present {
+			if !strings.Contains(got, field) {
+				t.Errorf("%s: %s, ожидается %s", test.name, got, field)
+			}
+		}
+		for _, field := range test.absent {
+			if strings.Contains(got, field) {
+				t.Errorf("%s: %s, не ожидается %s", test.name, got, field)
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package workmanager
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+// Тип - очередь рабочих потоков (двоичная куча) с заданным порядком
+type workerQueue struct {
+	items []*Worker
+	less  func(a, b *Worker) bool
+}
+
+func (q *workerQueue) Len() int           { return len(q.items) }
+func (q *workerQueue) Less(i, j int) bool { return q.less(q.items[i], q.items[j]) }
+
+func (q *workerQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *workerQueue) Push(x interface{}) {
+	worker := x.(*Worker)
+	worker.index = len(q.items)
+	worker.queue = q
+	q.items = append(q.items, worker)
+}
+
+func (q *workerQueue) Pop() interface{} {
+	n := len(q.items) - 1
+	worker := q.items[n]
+	q.items[n] = nil
+	q.items = q.items[:n]
+	worker.index = -1
+	worker.queue = nil
+	return worker
+}
+
+// scheduler - центральный планировщик проверок. Ожидающие проверки хранятся в куче по времени
+// следующего запуска, наступившие - в куче по приоритету. Одновременно выполняется не больше
+// threads проверок, поэтому число горутин не зависит от числа сервисов.
+type scheduler struct {
+	mutex   sync.Mutex
+	timers  workerQueue // ожидающие проверки, по времени запуска
+	ready   workerQueue // проверки, время которых наступило, по приоритету
+	threads int         // предельное число одновременных проверок
+	busy    int         // число выполняемых проверок
+	run     func(ctx context.Context, worker *Worker)
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Создание и запуск планировщика. run выполняет одну проверку рабочего потока и должна
+// прекратить её при отмене ctx.
+//----------------------------------------------------------------------------------------------------------------------
+func newScheduler(threads int, run func(ctx context.Context, worker *Worker)) *scheduler {
+	s := &scheduler{
+		timers:  workerQueue{less: func(a, b *Worker) bool { return a.next.Before(b.next) }},
+		threads: threads,
+		run:     run,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	// Из наступивших проверок первой запускается проверка с большим приоритетом,
+	// при равном приоритете - дольше ожидающая
+	s.ready = workerQueue{less: func(a, b *Worker) bool {
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.next.Before(b.next)
+	}}
+	metrics.SetGauge("ws_scheduler_threads", "Предельное число одновременных проверок (max_check_threads)", nil, float64(threads))
+	go s.dispatch()
+	return s
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Изменение числа потоков проверки. При уменьшении выполняемые проверки завершаются, новые
+// не запускаются, пока число занятых потоков не станет меньше предела.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) resize(threads int) {
+	s.mutex.Lock()
+	s.threads = threads
+	s.mutex.Unlock()
+	metrics.SetGauge("ws_scheduler_threads", "Предельное число одновременных проверок (max_check_threads)", nil, float64(threads))
+	s.signal()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Постановка рабочего потока в расписание. Первая проверка - через интервал или по расписанию сервиса.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) add(worker *Worker) {
+	s.mutex.Lock()
+	worker.removed = false
+	worker.added = time.Now()
+	worker.next = worker.nextRun(worker.added, worker.added)
+	heap.Push(&s.timers, worker)
+	s.mutex.Unlock()
+	s.signal()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Снятие рабочего потока с расписания. Выполняемая проверка отменяется и ожидается её завершение:
+// после возврата состояние рабочего потока больше никто не изменяет.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) remove(worker *Worker) {
+	s.mutex.Lock()
+	worker.removed = true
+	if worker.queue != nil {
+		heap.Remove(worker.queue, worker.index)
+	}
+	var finished chan struct{}
+	if worker.running {
+		worker.cancel()
+		finished = worker.finished
+	}
+	s.mutex.Unlock()
+	if finished != nil {
+		log.Debugf("scheduler, ожидание завершения проверки рабочего потока %d", worker.ID)
+		<-finished
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Снятие с расписания зависшего рабочего потока без ожидания. Выполняемая проверка отменяется,
+// её поток сразу считается свободным, даже если проверка не завершится.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) abandon(worker *Worker) {
+	s.mutex.Lock()
+	worker.removed = true
+	if worker.queue != nil {
+		heap.Remove(worker.queue, worker.index)
+	}
+	if worker.running && !worker.abandoned {
+		worker.cancel()
+		worker.abandoned = true
+		s.busy--
+	}
+	s.mutex.Unlock()
+	s.signal()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Положение рабочего потока в планировщике: выполняется проверка, проверка ожидает свободного
+// потока, перезапуск после сбоя ожидает супервизор, время постановки в расписание
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) state(worker *Worker) (running bool, waiting bool, crashed bool, added time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return worker.running, worker.queue == &s.ready, worker.crashes > 0, worker.added
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Состояние рабочего потока под наблюдением супервизора: CRASHED или CIRCUIT_OPEN, пустая строка,
+// если последняя проверка выполнена без сбоя; число сбоев подряд и текст последней паники
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) supervision(worker *Worker) (state string, crashes int, lastPanic string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case worker.circuitOpen:
+		state = WorkerStateCircuitOpen
+	case worker.crashes > 0:
+		state = WorkerStateCrashed
+	}
+	return state, worker.crashes, worker.lastPanic
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка планировщика. Выполняемые проверки не прерываются.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) stop() {
+	close(s.done)
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Цикл планировщика: перенос наступивших проверок в очередь готовых и запуск готовых
+// на свободных потоках. Цикл просыпается к ближайшему времени проверки, при изменении
+// расписания и при освобождении потока.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) dispatch() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		s.mutex.Lock()
+		now := time.Now()
+		for s.timers.Len() > 0 && !s.timers.items[0].next.After(now) {
+			heap.Push(&s.ready, heap.Pop(&s.timers))
+		}
+		for s.busy < s.threads && s.ready.Len() > 0 {
+			worker := heap.Pop(&s.ready).(*Worker)
+			s.start(worker, now)
+		}
+		wait := time.Hour
+		if s.timers.Len() > 0 {
+			wait = s.timers.items[0].next.Sub(now)
+		}
+		metrics.SetGauge("ws_scheduler_queue_length", "Число проверок, время которых наступило, в ожидании свободного потока",
+			nil, float64(s.ready.Len()))
+		metrics.SetGauge("ws_scheduler_busy_threads", "Число выполняемых проверок", nil, float64(s.busy))
+		s.mutex.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Запуск проверки на свободном потоке с учётом задержки относительно времени по плану.
+// Вызывается с захваченным мьютексом.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) start(worker *Worker, now time.Time) {
+	lag := now.Sub(worker.next)
+	metrics.SetGauge("ws_scheduler_queue_lag_seconds", "Задержка запуска последней проверки относительно времени по плану",
+		nil, lag.Seconds())
+	metrics.AddCounter("ws_scheduler_lag_seconds_total", "Суммарная задержка запуска проверок", nil, lag.Seconds())
+	metrics.AddCounter("ws_scheduler_checks_total", "Количество запущенных проверок", nil, 1)
+	if lag > time.Second {
+		log.Debugf("scheduler, проверка рабочего потока %d запущена с задержкой %.3f секунд", worker.ID, lag.Seconds())
+	}
+	s.busy++
+	worker.running = true
+	worker.finished = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	worker.cancel = cancel
+	go s.execute(ctx, worker)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Выполнение проверки и постановка следующей в расписание
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) execute(ctx context.Context, worker *Worker) {
+	startTime := time.Now()
+	err := s.supervise(ctx, worker)
+	endTime := time.Now()
+	log.Debugf("checkWebService [%d], Длительность выполнения рабочей проверки: %.3f секунд", worker.ID,
+		endTime.Sub(startTime).Seconds())
+
+	s.mutex.Lock()
+	// Поток брошенной проверки уже освобождён
+	if !worker.abandoned {
+		s.busy--
+	}
+	worker.cancel()
+	worker.running = false
+	close(worker.finished)
+	if !worker.removed {
+		worker.next = worker.supervised(err, worker.nextRun(startTime, endTime), endTime)
+		heap.Push(&s.timers, worker)
+		log.Debugf("checkWebService [%d], Следующая проверка: %s", worker.ID, worker.next.Format("15:04:05.000"))
+	}
+	s.mutex.Unlock()
+	s.signal()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Время следующей проверки после проверки, начатой в start и завершённой в end: по расписанию,
+// если оно задано, иначе через интервал от начала проверки. К времени добавляется случайная
+// задержка до jitter, чтобы проверки с одинаковым интервалом не запускались одновременно.
+//----------------------------------------------------------------------------------------------------------------------
+func (worker *Worker) nextRun(start time.Time, end time.Time) time.Time {
+	next := start.Add(worker.Interval)
+	if worker.Schedule != nil {
+		if next = worker.Schedule.Next(end); next.IsZero() {
+			// Расписание не срабатывает никогда: проверка откладывается на год
+			next = end.Add(365 * 24 * time.Hour)
+		}
+	}
+	if worker.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(worker.Jitter))))
+	}
+	return next
+}
@@ -0,0 +1,206 @@
+package workmanager
+
+import (
+	"container/heap"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+	"ws_monitoring/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitConsoleLogger(ioutil.Discard, "error")
+	os.Exit(m.Run())
+}
+
+func testWorker(name string, interval time.Duration) *Worker {
+	return newWorker(helper.Service{Name: name, Address: "http://" + name + "/", Enabled: true,
+		CheckInterval: helper.Duration(interval)})
+}
+
+func TestWorkerQueueOrder(t *testing.T) {
+	base := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	s := newScheduler(1, func(ctx context.Context, worker *Worker) {})
+	s.stop()
+
+	tests := []struct {
+		name     string
+		queue    *workerQueue
+		priority []int
+		offset   []int // смещение времени запуска, в секундах
+		want     []string
+	}{
+		{"ожидающие - по времени запуска", &s.timers, []int{0, 10, 5}, []int{30, 10, 20}, []string{"w1", "w2", "w0"}},
+		{"наступившие - по приоритету", &s.ready, []int{0, 10, 5}, []int{0, 2, 1}, []string{"w1", "w2", "w0"}},
+		{"равный приоритет - дольше ожидающий", &s.ready, []int{1, 1, 1}, []int{3, 1, 2}, []string{"w1", "w2", "w0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Очереди защищены мьютексом: цикл планировщика мог не завершиться после stop
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			for i := range test.priority {
+				worker := testWorker("w"+string(rune('0'+i)), time.Minute)
+				worker.Priority = test.priority[i]
+				worker.next = base.Add(time.Duration(test.offset[i]) * time.Second)
+				heap.Push(test.queue, worker)
+			}
+			var got []string
+			for test.queue.Len() > 0 {
+				worker := heap.Pop(test.queue).(*Worker)
+				if worker.index != -1 || worker.queue != nil {
+					t.Errorf("%s: после извлечения index %d, queue %p", worker.Name, worker.index, worker.queue)
+				}
+				got = append(got, worker.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("порядок %v, ожидается %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWorkerQueueRemove(t *testing.T) {
+	q := &workerQueue{less: func(a, b *Worker) bool { return a.next.Before(b.next) }}
+	base := time.Now()
+	workers := make([]*Worker, 5)
+	for i := range workers {
+		workers[i] = testWorker("w"+string(rune('0'+i)), time.Minute)
+		workers[i].next = base.Add(time.Duration(5-i) * time.Second)
+		heap.Push(q, workers[i])
+	}
+	heap.Remove(q, workers[2].index)
+	heap.Remove(q, workers[4].index)
+	var got []string
+	for q.Len() > 0 {
+		got = append(got, heap.Pop(q).(*Worker).Name)
+	}
+	if want := []string{"w3", "w1", "w0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("после удаления порядок %v, ожидается %v", got, want)
+	}
+}
+
+// Наступившие проверки запускаются по приоритету, одновременно не больше threads
+func TestSchedulerPriorityAndThreads(t *testing.T) {
+	var mutex sync.Mutex
+	var order []string
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	s := newScheduler(0, func(ctx context.Context, worker *Worker) {
+		mutex.Lock()
+		order = append(order, worker.Name)
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	})
+	defer s.stop()
+
+	var workers []*Worker
+	for i, priority := range []int{1, 5, 3, 0} {
+		worker := testWorker("w"+string(rune('0'+i)), 20*time.Millisecond)
+		worker.Priority = priority
+		workers = append(workers, worker)
+		s.add(worker)
+	}
+	// Без свободных потоков все проверки остаются в очереди наступивших
+	time.Sleep(100 * time.Millisecond)
+	s.mutex.Lock()
+	ready := s.ready.Len()
+	for _, worker := range workers {
+		// Следующая проверка выполненных сервисов не наступит до конца теста
+		worker.Interval = time.Hour
+	}
+	s.mutex.Unlock()
+	if ready != 4 {
+		t.Fatalf("в очереди наступивших %d проверок, ожидается 4", ready)
+	}
+
+	s.resize(2)
+	deadline := time.Now().Add(time.Second)
+	for {
+		mutex.Lock()
+		started := len(order)
+		mutex.Unlock()
+		if started >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mutex.Lock()
+	if want := []string{"w1", "w2"}; len(order) != 2 || !reflect.DeepEqual(sortedNames(order), want) || maxRunning != 2 {
+		t.Errorf("запущены %v (одновременно %d), ожидается %v не больше двух", order, maxRunning, want)
+	}
+	mutex.Unlock()
+
+	release <- struct{}{}
+	time.Sleep(50 * time.Millisecond)
+	mutex.Lock()
+	if len(order) < 3 || order[2] != "w0" || maxRunning != 2 {
+		t.Errorf("после освобождения потока запущены %v (одновременно %d), ожидается w0", order, maxRunning)
+	}
+	mutex.Unlock()
+	close(release)
+}
+
+func sortedNames(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestNextRun(t *testing.T) {
+	start := time.Date(2024, 3, 4, 12, 0, 10, 0, time.UTC)
+	end := start.Add(3 * time.Second)
+	every5, _ := helper.ParseSchedule("*/5 * * * *")
+	never, _ := helper.ParseSchedule("0 0 30 feb *")
+	tests := []struct {
+		name     string
+		interval time.Duration
+		schedule *helper.Schedule
+		want     time.Time
+	}{
+		{"интервал от начала проверки", time.Minute, nil, start.Add(time.Minute)},
+		{"расписание после окончания проверки", time.Minute, every5, time.Date(2024, 3, 4, 12, 5, 0, 0, time.UTC)},
+		{"расписание не срабатывает", time.Minute, never, end.Add(365 * 24 * time.Hour)},
+	}
+	for _, test := range tests {
+		worker := testWorker("w", test.interval)
+		worker.Schedule = test.schedule
+		if got := worker.nextRun(start, end); !got.Equal(test.want) {
+			t.Errorf("%s: %v, ожидается %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNextRunJitter(t *testing.T) {
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	worker := testWorker("w", time.Minute)
+	worker.Jitter = 10 * time.Second
+	seen := make(map[time.Time]bool)
+	for i := 0; i < 100; i++ {
+		next := worker.nextRun(start, start)
+		if next.Before(start.Add(time.Minute)) || !next.Before(start.Add(time.Minute+worker.Jitter)) {
+			t.Fatalf("время %v вне интервала [1m, 1m+jitter)", next.Sub(start))
+		}
+		seen[next] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("различных значений %d из 100: задержка не случайна", len(seen))
+	}
+}
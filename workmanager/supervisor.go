@@ -0,0 +1,79 @@
+package workmanager
+
+import (
+	"context"
+	"time"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+// Перезапуск проверок после паники
+const (
+	restartBackoff    = time.Second      // задержка перезапуска после первого сбоя, удваивается с каждым сбоем подряд
+	maxRestartBackoff = 5 * time.Minute  // предельная задержка перезапуска
+	circuitCrashes    = 5                // число сбоев подряд, после которого цепь размыкается
+	circuitOpenTime   = 30 * time.Minute // пауза разомкнутой цепи до пробной проверки
+)
+
+// Состояния рабочего потока под наблюдением супервизора
+const (
+	WorkerStateCrashed     = "CRASHED"      // паника в проверке, ожидается перезапуск
+	WorkerStateCircuitOpen = "CIRCUIT_OPEN" // проверки приостановлены после повторяющихся сбоев
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+// Выполнение проверки под наблюдением супервизора. Паника не завершает программу: стек вызовов
+// записывается в лог с именем сервиса, паника возвращается как ошибка.
+//----------------------------------------------------------------------------------------------------------------------
+func (s *scheduler) supervise(ctx context.Context, worker *Worker) (err error) {
+	defer CatchPanic(&err, "checkWebService", "сервис "+worker.Name)
+	s.run(ctx, worker)
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Время следующей проверки с учётом сбоев. После паники проверка перезапускается с задержкой,
+// которая удваивается с каждым сбоем подряд. После circuitCrashes сбоев подряд цепь размыкается:
+// проверки приостанавливаются на circuitOpenTime, затем выполняется пробная проверка.
+// Успешная проверка замыкает цепь. Вызывается с захваченным мьютексом планировщика.
+//----------------------------------------------------------------------------------------------------------------------
+func (worker *Worker) supervised(err error, next time.Time, now time.Time) time.Time {
+	labels := map[string]string{"service": worker.Name}
+	if err == nil {
+		if worker.crashes > 0 {
+			log.Infof("supervisor, проверка сервиса %s выполнена после сбоев подряд: %d", worker.Name, worker.crashes)
+			metrics.SetGauge("ws_worker_circuit_open", "Проверки сервиса приостановлены после повторяющихся сбоев: 1 - да",
+				labels, 0)
+		}
+		worker.crashes = 0
+		worker.circuitOpen = false
+		return next
+	}
+
+	worker.crashes++
+	worker.lastPanic = err.Error()
+	metrics.AddCounter("ws_worker_panics_total", "Количество сбоев (паник) при проверке сервиса", labels, 1)
+	if worker.crashes >= circuitCrashes {
+		if !worker.circuitOpen {
+			log.Errorf("supervisor, сервис %s: сбоев подряд %d, проверки приостановлены на %v",
+				worker.Name, worker.crashes, circuitOpenTime)
+			metrics.SetGauge("ws_worker_circuit_open", "Проверки сервиса приостановлены после повторяющихся сбоев: 1 - да",
+				labels, 1)
+		} else {
+			log.Errorf("supervisor, сервис %s: сбой пробной проверки, проверки приостановлены на %v", worker.Name, circuitOpenTime)
+		}
+		worker.circuitOpen = true
+		return now.Add(circuitOpenTime)
+	}
+
+	backoff := maxRestartBackoff
+	if shift := uint(worker.crashes - 1); shift < 32 && restartBackoff<<shift < maxRestartBackoff {
+		backoff = restartBackoff << shift
+	}
+	log.Errorf("supervisor, сервис %s: сбой проверки (%d подряд): %v, перезапуск через %v",
+		worker.Name, worker.crashes, err, backoff)
+	if restart := now.Add(backoff); restart.After(next) {
+		return restart
+	}
+	return next
+}
@@ -0,0 +1,84 @@
+package workmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervisedBackoffAndCircuit(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	next := now.Add(10 * time.Second) // следующая проверка по плану
+	crash := errors.New("panic: boom")
+	tests := []struct {
+		name    string
+		err     error
+		next    time.Time
+		want    time.Time
+		crashes int
+		state   string
+	}{
+		{"первый сбой: перезапуск по плану раньше задержки", crash, now.Add(500 * time.Millisecond), now.Add(time.Second), 1, WorkerStateCrashed},
+		{"второй сбой: задержка 2s", crash, next, next, 2, WorkerStateCrashed},
+		{"третий сбой: задержка 4s", crash, next, next, 3, WorkerStateCrashed},
+		{"четвёртый сбой: задержка 8s", crash, now, now.Add(8 * time.Second), 4, WorkerStateCrashed},
+		{"пятый сбой: цепь размыкается", crash, next, now.Add(circuitOpenTime), 5, WorkerStateCircuitOpen},
+		{"сбой пробной проверки", crash, next, now.Add(circuitOpenTime), 6, WorkerStateCircuitOpen},
+		{"успешная проверка замыкает цепь", nil, next, next, 0, ""},
+		{"сбой после восстановления: снова 1s", crash, now, now.Add(time.Second), 1, WorkerStateCrashed},
+	}
+	s := &scheduler{}
+	worker := testWorker("api", 10*time.Second)
+	for _, test := range tests {
+		s.mutex.Lock()
+		got := worker.supervised(test.err, test.next, now)
+		s.mutex.Unlock()
+		if !got.Equal(test.want) {
+			t.Errorf("%s: следующая проверка через %v, ожидается через %v", test.name, got.Sub(now), test.want.Sub(now))
+		}
+		state, crashes, lastPanic := s.supervision(worker)
+		if state != test.state || crashes != test.crashes {
+			t.Errorf("%s: состояние %q, сбоев %d, ожидается %q и %d", test.name, state, crashes, test.state, test.crashes)
+		}
+		if test.err != nil && lastPanic != test.err.Error() {
+			t.Errorf("%s: последняя паника %q", test.name, lastPanic)
+		}
+	}
+}
+
+func TestSupervisedBackoffDoubles(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		crashes int // сбоев до текущего
+		want    time.Duration
+	}{
+		{0, restartBackoff},
+		{2, 4 * restartBackoff},
+		{circuitCrashes - 2, restartBackoff << uint(circuitCrashes-2)},
+	}
+	for _, test := range tests {
+		worker := testWorker("api", time.Second)
+		worker.crashes = test.crashes
+		if got := worker.supervised(errors.New("boom"), now, now).Sub(now); got != test.want || got > maxRestartBackoff {
+			t.Errorf("после %d сбоев задержка %v, ожидается %v", test.crashes, got, test.want)
+		}
+	}
+}
+
+func TestSupervisePanic(t *testing.T) {
+	s := &scheduler{run: func(ctx context.Context, worker *Worker) {
+		var m map[string]int
+		m[worker.Name]++
+	}}
+	err := s.supervise(context.Background(), testWorker("api", time.Second))
+	if err == nil || !strings.Contains(err.Error(), "nil map") {
+		t.Errorf("ошибка %v, ожидается паника записи в nil map", err)
+	}
+
+	s.run = func(ctx context.Context, worker *Worker) {}
+	if err := s.supervise(context.Background(), testWorker("api", time.Second)); err != nil {
+		t.Errorf("ошибка %v для проверки без паники", err)
+	}
+}
@@ -0,0 +1,144 @@
+package workmanager
+
+import (
+	"time"
+	"ws_monitoring/log"
+	"ws_monitoring/metrics"
+)
+
+// Период проверки контрольных сигналов рабочих потоков
+const watchdogInterval = 5 * time.Second
+
+//----------------------------------------------------------------------------------------------------------------------
+// Сторожевой таймер. Контрольный сигнал рабочего потока - время завершения последней проверки
+// (LastStateTime). Рабочий поток, пропустивший missed ожидаемых сигналов подряд, отмечается
+// состоянием STALLED. Зависшая проверка отменяется и рабочий поток перезапускается. Проверка,
+// которая ждёт свободного потока, не перезапускается: перезапуск не ускорит её, нужно увеличить
+// max_check_threads. Состояние OK восстанавливается после следующего контрольного сигнала.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) watchdog(missed int, now time.Time) {
+	workManager.mutex.RLock()
+	workers := make(WorkersList, 0, len(workManager.Workers))
+	for _, worker := range workManager.Workers {
+		if worker.State {
+			workers = append(workers, worker)
+		}
+	}
+	workManager.mutex.RUnlock()
+
+	for _, worker := range workers {
+		running, waiting, crashed, added := workManager.scheduler.state(worker)
+		if crashed {
+			// Перезапуск после сбоя с задержкой выполняет супервизор
+			continue
+		}
+
+		workManager.mutex.Lock()
+		heartbeat := worker.LastStateTime
+		since := heartbeat
+		if added.After(since) {
+			since = added
+		}
+		deadline := worker.heartbeatDeadline(since, missed)
+		overdue := !deadline.IsZero() && now.After(deadline)
+		stalled := worker.WorkerState == WorkerStateStalled
+		if overdue && !stalled {
+			worker.WorkerState = WorkerStateStalled
+			worker.stalledSince = now
+		}
+		if !overdue && stalled && heartbeat.After(worker.stalledSince) {
+			worker.WorkerState = WorkerStateOK
+		}
+		state := worker.WorkerState
+		workManager.mutex.Unlock()
+
+		labels := map[string]string{"service": worker.Name}
+		switch {
+		case overdue && !stalled:
+			reason := "проверка выполняется"
+			if waiting {
+				reason = "проверка ожидает свободного потока, увеличьте max_check_threads"
+			} else if !running {
+				reason = "проверка не запланирована"
+			}
+			log.Errorf("watchdog, рабочий поток %d сервиса %s: %s, пропущено контрольных сигналов %d, последний %s: %s",
+				worker.ID, worker.Name, WorkerStateStalled, missed, formatHeartbeat(heartbeat), reason)
+		case state == WorkerStateOK && stalled:
+			log.Infof("watchdog, рабочий поток %d сервиса %s: получен контрольный сигнал, состояние %s",
+				worker.ID, worker.Name, WorkerStateOK)
+		}
+		stalledValue := 0.0
+		if state == WorkerStateStalled {
+			stalledValue = 1
+		}
+		metrics.SetGauge("ws_worker_stalled", "Рабочий поток пропустил контрольные сигналы: 1 - STALLED", labels, stalledValue)
+
+		if overdue && !waiting {
+			workManager.restartWorker(worker)
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Перезапуск зависшего рабочего потока: выполняемая проверка отменяется без ожидания завершения,
+// рабочий поток заменяется новым с тем же состоянием сервиса.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) restartWorker(old *Worker) {
+	workManager.scheduler.abandon(old)
+	worker := newWorker(old.Service)
+
+	workManager.mutex.Lock()
+	worker.inheritState(old)
+	worker.Restarts++
+	old.State = false
+	for i := range workManager.Workers {
+		if workManager.Workers[i] == old {
+			workManager.Workers[i] = worker
+		}
+	}
+	workManager.mutex.Unlock()
+
+	log.Infof("watchdog, перезапуск рабочего потока %d сервиса %s, новый номер %d", old.ID, old.Name, worker.ID)
+	metrics.AddCounter("ws_worker_restarts_total", "Количество перезапусков рабочего потока сторожевым таймером",
+		map[string]string{"service": worker.Name}, 1)
+	workManager.startWorker(worker)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Перенос состояния сервиса и контрольных сигналов из прежнего рабочего потока в новый
+//----------------------------------------------------------------------------------------------------------------------
+func (worker *Worker) inheritState(old *Worker) {
+	worker.ServiceState = old.ServiceState
+	worker.StateSince = old.StateSince
+	worker.LastStateTime = old.LastStateTime
+	worker.backends = old.backends
+	worker.Restarts = old.Restarts
+	worker.degraded = old.degraded
+	if old.WorkerState != "" && worker.WorkerState != "" {
+		worker.WorkerState = old.WorkerState
+		worker.stalledSince = old.stalledSince
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Время, к которому рабочий поток должен прислать контрольный сигнал: missed проверок по интервалу
+// или расписанию после since с учётом случайной задержки. Нулевое время - сигнал не ожидается.
+//----------------------------------------------------------------------------------------------------------------------
+func (worker *Worker) heartbeatDeadline(since time.Time, missed int) time.Time {
+	deadline := since
+	for i := 0; i < missed; i++ {
+		if worker.Schedule == nil {
+			deadline = deadline.Add(worker.Interval)
+		} else if deadline = worker.Schedule.Next(deadline); deadline.IsZero() {
+			return deadline
+		}
+	}
+	return deadline.Add(worker.Jitter)
+}
+
+func formatHeartbeat(t time.Time) string {
+	if t.IsZero() {
+		return "не было"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,80 @@
+package workmanager
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+	"ws_monitoring/helper"
+)
+
+func TestHeartbeatDeadline(t *testing.T) {
+	since := time.Date(2024, 3, 4, 12, 0, 30, 0, time.UTC)
+	every5, _ := helper.ParseSchedule("*/5 * * * *")
+	never, _ := helper.ParseSchedule("0 0 30 feb *")
+	tests := []struct {
+		name     string
+		interval time.Duration
+		schedule *helper.Schedule
+		jitter   time.Duration
+		missed   int
+		want     time.Time
+	}{
+		{"интервал", 10 * time.Second, nil, 0, 3, since.Add(30 * time.Second)},
+		{"интервал и случайная задержка", 10 * time.Second, nil, 5 * time.Second, 3, since.Add(35 * time.Second)},
+		{"один сигнал", time.Minute, nil, 0, 1, since.Add(time.Minute)},
+		{"расписание", time.Minute, every5, 0, 3, time.Date(2024, 3, 4, 12, 15, 0, 0, time.UTC)},
+		{"расписание и случайная задержка", time.Minute, every5, time.Minute, 2,
+			time.Date(2024, 3, 4, 12, 11, 0, 0, time.UTC)},
+		{"расписание не срабатывает", time.Minute, never, time.Minute, 3, time.Time{}},
+	}
+	for _, test := range tests {
+		worker := testWorker("api", test.interval)
+		worker.Schedule = test.schedule
+		worker.Jitter = test.jitter
+		if got := worker.heartbeatDeadline(since, test.missed); !got.Equal(test.want) {
+			t.Errorf("%s: срок %v, ожидается %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Проверка, ожидающая свободного потока, отмечается STALLED без перезапуска
+// и возвращается в OK после следующего контрольного сигнала
+func TestWatchdogWaitingWorker(t *testing.T) {
+	s := newScheduler(0, nil)
+	defer s.stop()
+	worker := testWorker("api", 10*time.Second)
+	added := time.Now().Add(-time.Minute)
+	s.mutex.Lock()
+	worker.added = added
+	worker.next = added
+	heap.Push(&s.ready, worker)
+	s.mutex.Unlock()
+	m := &workManager{Workers: WorkersList{worker}, scheduler: s}
+
+	tests := []struct {
+		name      string
+		heartbeat time.Time
+		now       time.Time
+		want      string
+	}{
+		{"сигналы ещё не пропущены", time.Time{}, added.Add(29 * time.Second), WorkerStateOK},
+		{"пропущено три сигнала", time.Time{}, added.Add(31 * time.Second), WorkerStateStalled},
+		{"без нового сигнала состояние сохраняется", time.Time{}, added.Add(5 * time.Second), WorkerStateStalled},
+		{"получен контрольный сигнал", added.Add(40 * time.Second), added.Add(45 * time.Second), WorkerStateOK},
+	}
+	for _, test := range tests {
+		m.mutex.Lock()
+		worker.LastStateTime = test.heartbeat
+		m.mutex.Unlock()
+		m.watchdog(3, test.now)
+		m.mutex.RLock()
+		state, current := worker.WorkerState, m.Workers[0]
+		m.mutex.RUnlock()
+		if state != test.want {
+			t.Errorf("%s: состояние %s, ожидается %s", test.name, state, test.want)
+		}
+		if current != worker {
+			t.Fatalf("%s: ожидающий рабочий поток перезапущен", test.name)
+		}
+	}
+}
@@ -1,34 +1,83 @@
 package workmanager
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
+	"ws_monitoring/alert"
+	"ws_monitoring/baseline"
 	"ws_monitoring/helper"
 	"ws_monitoring/log"
-//"gopkg.in/fatih/pool.v2"
-//"net"
+	"ws_monitoring/metrics"
+	"ws_monitoring/slo"
+	// "gopkg.in/fatih/pool.v2"
+	// "net"
 )
 
 // Тип - идентификатор рабочего потока
 type WorkerID int
 
-// Тип - команда управления рабочим потоком
-type Command bool
+// Состояния web-сервиса по результатам проверок
+const (
+	ServiceStateUp          = "up"
+	ServiceStateWarning     = "warning"
+	ServiceStateDown        = "down"
+	ServiceStateMaintenance = "maintenance"
+	ServiceStateUnreachable = "unreachable" // недоступна зависимость сервиса
+)
+
+// Состояния рабочего потока по контрольным сигналам и базовой линии времени ответа
+const (
+	WorkerStateOK       = "OK"
+	WorkerStateStalled  = "STALLED"  // пропущены контрольные сигналы
+	WorkerStateDegraded = "DEGRADED" // время ответа выше базовой линии
+)
 
 // Тип - рабочий поток
 type Worker struct {
 	ID            WorkerID
 	LastStateTime time.Time
 	State         bool
+	Name          string
+	Tags          map[string]string
+	DependsOn     []string
 	URL           string
 	Login         string
-	Password      string
+	Password      helper.Secret
 	Interval      time.Duration
-	CommandChan   chan Command
+	Schedule      *helper.Schedule // расписание проверок, если задано, вместо интервала
+	Priority      int              // порядок запуска наступивших проверок при нехватке потоков
+	Jitter        time.Duration    // предельная случайная задержка проверки
 	Req           *http.Request
+	ServiceState  string    // последнее известное состояние сервиса
+	StateSince    time.Time // время перехода сервиса в текущее состояние
+	WarnLatency   time.Duration
+	CritLatency   time.Duration
+	StatusCodes   map[string]string
+	Service       helper.Service  // настройки, по которым создан рабочий поток, для сравнения при перезагрузке
+	backends      map[string]bool // адреса узлов последней проверки при per_backend
+	WorkerState   string          // OK или STALLED по контрольным сигналам
+	stalledSince  time.Time       // время обнаружения пропуска контрольных сигналов
+	Restarts      int             // число перезапусков сторожевым таймером
+	degraded      bool            // время ответа выше базовой линии по последней учтённой проверке
+
+	// Состояние в планировщике, защищено мьютексом планировщика
+	next        time.Time          // время следующей проверки
+	added       time.Time          // время постановки в расписание
+	queue       *workerQueue       // очередь, в которой находится рабочий поток
+	index       int                // позиция в очереди
+	running     bool               // выполняется проверка
+	removed     bool               // снят с расписания
+	abandoned   bool               // выполняемая проверка брошена сторожевым таймером
+	crashes     int                // число сбоев проверки подряд
+	lastPanic   string             // текст последней паники
+	circuitOpen bool               // проверки приостановлены после повторяющихся сбоев
+	cancel      context.CancelFunc // отмена выполняемой проверки
+	finished    chan struct{}      // закрывается по завершении выполняемой проверки
 }
 
 // Тип - cписок рабочих потоков
@@ -36,24 +85,52 @@ type WorkersList []*Worker
 
 // workManager - синглтон, контролирует запуск и остановку рабочих потоков.
 type workManager struct {
+	mutex           sync.RWMutex // защищает список рабочих потоков и состояние сервисов
 	Workers         WorkersList
+	canaries        map[string]*canary
+	config          ConfigStatus // результат последней загрузки конфигурации
+	collectorURL    string       // адрес сборщика данных из действующей конфигурации
+	scheduler       *scheduler   // планировщик проверок сервисов
 	Shutdown        int32
 	ShutdownChannel chan string
+	ReloadChannel   chan bool // запрос немедленной перезагрузки конфигурации
+}
+
+// WorkerStatus - состояние рабочего потока для HTTP API
+type WorkerStatus struct {
+	ID            WorkerID   `json:"id"`
+	Name          string     `json:"name"`
+	Address       string     `json:"address"`
+	Enabled       bool       `json:"enabled"`
+	ServiceState  string     `json:"state"`
+	StateSince    *time.Time `json:"state_since,omitempty"` // nil до первой проверки
+	LastStateTime time.Time  `json:"last_heartbeat"`
+	WorkerState   string     `json:"worker_state,omitempty"` // OK, DEGRADED, STALLED, CRASHED или CIRCUIT_OPEN, для включённых сервисов
+	Restarts      int        `json:"restarts"`
+	Crashes       int        `json:"crashes"`              // сбоев проверки подряд
+	LastPanic     string     `json:"last_panic,omitempty"` // текст последней паники в проверке
 }
 
 type CheckResult struct {
-	CheckTime     string        `json:"time"`
-	CheckDuration time.Duration `json:"duration"`
-	Address       string        `json:"address"`
-	StatusCode    int           `json:"status"`
-	Error         string        `json:"error"`
+	CheckTime     string         `json:"time"`
+	CheckDuration time.Duration  `json:"duration"`
+	Address       string         `json:"address"`
+	StatusCode    int            `json:"status"`
+	Error         string         `json:"error"`
+	State         string         `json:"state"`
+	Severity      string         `json:"severity"`
+	Reason        string         `json:"reason,omitempty"`   // причина уровня WARNING или CRITICAL
+	Degraded      bool           `json:"degraded,omitempty"` // время ответа выше базовой линии (DEGRADED)
+	Backend       string         `json:"backend,omitempty"`  // адрес узла для результата проверки узла
+	Backends      []*CheckResult `json:"backends,omitempty"` // результаты узлов при per_backend
 }
 
 var (
-	wm workManager // Reference to the singleton
-	aliveWorkerChan  chan WorkerID
-	workerIDSequence WorkerID = 0
-//		chPool pool.Pool
+	wm               workManager // Reference to the singleton
+	workerIDSequence WorkerID    = 0
+	reloadHooks      []func(cfg *helper.Config)
+
+// chPool pool.Pool
 )
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -69,7 +146,9 @@ func Startup(cfg *helper.Config) error {
 	wm = workManager{
 		Shutdown:        0,
 		ShutdownChannel: make(chan string),
+		ReloadChannel:   make(chan bool, 1),
 	}
+	wm.scheduler = newScheduler(cfg.MaxCheckThreads, wm.CheckWebService)
 
 	//// create a factory() to be used with channel based pool
 	//factory := func() (net.Conn, error) {
@@ -113,6 +192,51 @@ func Shutdown() error {
 	return err
 }
 
+//----------------------------------------------------------------------------------------------------------------------
+// Регистрация функции, вызываемой после перезагрузки конфигурации
+//----------------------------------------------------------------------------------------------------------------------
+func OnReload(hook func(cfg *helper.Config)) {
+	reloadHooks = append(reloadHooks, hook)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Снимок состояния рабочих потоков для HTTP API
+//----------------------------------------------------------------------------------------------------------------------
+func Status() []WorkerStatus {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+	list := make([]WorkerStatus, 0, len(wm.Workers))
+	for _, worker := range wm.Workers {
+		supervision, crashes, lastPanic := wm.scheduler.supervision(worker)
+		workerState := worker.WorkerState
+		if workerState == WorkerStateOK && worker.degraded {
+			workerState = WorkerStateDegraded
+		}
+		if supervision != "" {
+			workerState = supervision
+		}
+		var stateSince *time.Time
+		if !worker.StateSince.IsZero() {
+			since := worker.StateSince
+			stateSince = &since
+		}
+		list = append(list, WorkerStatus{
+			ID:            worker.ID,
+			Name:          worker.Name,
+			Address:       worker.URL,
+			Enabled:       worker.State,
+			ServiceState:  worker.ServiceState,
+			StateSince:    stateSince,
+			LastStateTime: worker.LastStateTime,
+			WorkerState:   workerState,
+			Restarts:      worker.Restarts,
+			Crashes:       crashes,
+			LastPanic:     lastPanic,
+		})
+	}
+	return list
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 // CatchPanic is used to catch and display panics.
 //----------------------------------------------------------------------------------------------------------------------
@@ -120,9 +244,9 @@ func CatchPanic(err *error, goRoutine string, function string) {
 	if r := recover(); r != nil {
 		// Capture the stack trace
 		buf := make([]byte, 10000)
-		runtime.Stack(buf, false)
+		buf = buf[:runtime.Stack(buf, false)]
 
-		log.Errorf(goRoutine, function, "PANIC Defered [%v] : Stack Trace : %v", r, string(buf))
+		log.Errorf("%s, %s: PANIC Defered [%v] : Stack Trace : %s", goRoutine, function, r, buf)
 
 		if err != nil {
 			*err = fmt.Errorf("%v", r)
@@ -135,84 +259,107 @@ func CatchPanic(err *error, goRoutine string, function string) {
 //----------------------------------------------------------------------------------------------------------------------
 func (workManager *workManager) WorkingLoop(cfg *helper.Config) {
 	//var workers WorkersList
-	log.Debugf("workingLoop, ожидание %d секунд", time.Duration(cfg.ReloadConfigInterval))
+	log.Debugf("workingLoop, период проверки изменений конфигурации %v", cfg.ReloadConfigInterval)
 
-	// Поток для контроля работоспособности рабочих потоков.
-	aliveWorkerChan = make(chan WorkerID)
+	workManager.configApplied(cfg, time.Now())
+	log.Debugf("workingLoop, число потоков проверки %d", cfg.MaxCheckThreads)
 
 	// Первоначальная инициализация списка рабочих потоков
 	log.Debugf("len(cfg.Services) = %d", len(cfg.Services))
 	workManager.InitWorkers(cfg)
+	workManager.InitCanaries(cfg)
 
-	// Запуск рабочих потоков
+	// Постановка рабочих потоков в расписание
 	for i := 0; i < len(workManager.Workers); i++ {
-		go workManager.CheckWebService(workManager.Workers[i], aliveWorkerChan, cfg.DataCollectorURL)
+		workManager.startWorker(workManager.Workers[i])
 	}
 
+	// Отслеживание изменений конфигурационного файла. Периодическая проверка остаётся
+	// на случай, если событие изменения потеряно или отслеживание не поддерживается.
+	var configEvents chan struct{}
+	watcher, err := helper.WatchConfig(helper.ConfigWatchPatterns())
+	if err != nil {
+		log.Errorf("workingLoop, изменения %s отслеживаются только периодической проверкой: %v", helper.ConfigFileName, err)
+	} else {
+		defer watcher.Close()
+		configEvents = watcher.Events
+	}
+
+	// Сторожевой таймер рабочих потоков
+	watchdog := time.NewTicker(watchdogInterval)
+	defer watchdog.Stop()
+
 	// Включение тикера
-	t := time.Tick(time.Duration(cfg.ReloadConfigInterval) * time.Second)
+	interval := cfg.ReloadConfigInterval
+	cfgThreads := cfg.MaxCheckThreads
+	ticker := time.NewTicker(interval.Duration())
+	defer func() { ticker.Stop() }()
 
 	for {
 		log.Debug("workingLoop, очередной цикл")
+		force := false
 		select {
 		case <-workManager.ShutdownChannel:
 			log.Info("workingLoop, закрытие рабочих потоков")
 			workManager.CloseWorkers()
+			workManager.scheduler.stop()
+			workManager.CloseCanaries()
 			log.Info("workingLoop, выключение контрольного потока")
 			workManager.ShutdownChannel <- "Down"
 			return
 
-		case <-t:
-		// Срабатывание таймера.
+		case <-workManager.ReloadChannel:
+			log.Info("workingLoop, запрошена перезагрузка конфигурации")
+			force = true
+
+		case <-configEvents:
+			log.Debug("workingLoop, изменён конфигурационный файл")
+
+		case <-ticker.C:
+			// Срабатывание таймера.
 			log.Debug("workingLoop, срабатывание таймера")
+
+		case <-watchdog.C:
+			workManager.watchdog(cfg.MissedHeartbeats, time.Now())
+			continue
+		}
+
 		// Контроль необходимости закрытия.
-			if workManager.Shutdown == 1 {
-				log.Debug("workingLoop, workManager.Shutdown == 1")
-				return
-			}
+		if workManager.Shutdown == 1 {
+			log.Debug("workingLoop, workManager.Shutdown == 1")
+			return
+		}
 		// Перезагрузка конфигурации
-			cfgTmp, err := helper.ReloadConfig(helper.ConfigFileName)
-			if err != nil {
-				if err != helper.ErrNotModified {
-					log.Fatalf("Не удалось загрузить %s: %s", helper.ConfigFileName, err)
-				} else {
-					log.Debugf("workingLoop, конфигурация не изменилась")
-					// ToDo - контроль рабочих потоков от которых давно не было подтверждения работоспособности
-				}
-			} else {
-				log.Info("Перезагружен конфигурационный файл")
-				if err := log.InitLogger(cfgTmp); err != nil {
-					log.Error(err)
-				} else {
-					cfg = cfgTmp
-				}
-
-				// ToDo - пересоздать тикер при изменении cfg.ReloadConfigInterval
-
-				// Закрыть предыдущие рабочие потоки.
-				workManager.CloseWorkers()
-
-				// Создать новый набор рабочих потоков
-				workManager.InitWorkers(cfg)
-
-				// Запуск рабочих потоков
-				for i := 0; i < len(workManager.Workers); i++ {
-					log.Debugf("workingLoop, запуск рабочего потока с номером %d", workManager.Workers[i].ID)
-					go workManager.CheckWebService(workManager.Workers[i], aliveWorkerChan, cfg.DataCollectorURL)
-				}
+		cfgNew := workManager.reloadConfig(force)
+		// Список подключаемых файлов мог измениться, даже если конфигурация отклонена
+		if watcher != nil {
+			if err := watcher.Update(helper.ConfigWatchPatterns()); err != nil {
+				log.Errorf("workingLoop, ошибка отслеживания подключаемых файлов: %v", err)
 			}
+		}
+		if cfgNew == nil {
+			continue
+		}
+		cfg = cfgNew
+		for _, hook := range reloadHooks {
+			hook(cfg)
+		}
 
-		// Контрольный сигнал от рабочего потока.
-		case workerID := <-aliveWorkerChan:
-			log.Debugf("Контрольный сигнал от рабочего потока: %+v", workerID)
-		// Обновить данные о рабочем потоке.
-			for i := 0; i < len(workManager.Workers); i++ {
-				if workManager.Workers[i].ID == workerID {
-					// Сохранить время получения контрольного сигнала.
-					workManager.Workers[i].LastStateTime = time.Now()
-				}
-			}
+		// Пересоздание тикера при изменении интервала
+		if cfg.ReloadConfigInterval != interval {
+			interval = cfg.ReloadConfigInterval
+			ticker.Stop()
+			ticker = time.NewTicker(interval.Duration())
 		}
+		if cfg.MaxCheckThreads != cfgThreads {
+			log.Infof("workingLoop, число потоков проверки %d -> %d", cfgThreads, cfg.MaxCheckThreads)
+			cfgThreads = cfg.MaxCheckThreads
+			workManager.scheduler.resize(cfgThreads)
+		}
+
+		// Перезапуск только добавленных, удалённых и изменённых сервисов
+		workManager.ReloadWorkers(cfg)
+		workManager.ReloadCanaries(cfg)
 	}
 }
 
@@ -220,23 +367,46 @@ func (workManager *workManager) WorkingLoop(cfg *helper.Config) {
 // Инициализация рабочих потоков
 //----------------------------------------------------------------------------------------------------------------------
 func (workManager *workManager) InitWorkers(cfg *helper.Config) {
+	workManager.mutex.Lock()
+	defer workManager.mutex.Unlock()
+	workManager.collectorURL = cfg.DataCollectorURL
 	workManager.Workers = make(WorkersList, len(cfg.Services))
 	for i, service := range cfg.Services {
-		//		if service.Enabled != true {
-		//			continue
-		//		}
-		workerIDSequence = workerIDSequence + 1
-		workManager.Workers[i] = new(Worker)
-		workManager.Workers[i].ID = workerIDSequence
-		workManager.Workers[i].State = service.Enabled
-		workManager.Workers[i].URL = service.Address
-		workManager.Workers[i].Login = service.Login
-		workManager.Workers[i].Password = service.Password
-		workManager.Workers[i].Interval = service.CheckInterval * time.Second
-		workManager.Workers[i].CommandChan = make(chan Command)
-		workManager.Workers[i].Req, _ = http.NewRequest("GET", workManager.Workers[i].URL, nil)
-		workManager.Workers[i].Req.SetBasicAuth(workManager.Workers[i].Login, workManager.Workers[i].Password)
+		workManager.Workers[i] = newWorker(service)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Создание рабочего потока по настройкам сервиса
+//----------------------------------------------------------------------------------------------------------------------
+func newWorker(service helper.Service) *Worker {
+	workerIDSequence = workerIDSequence + 1
+	worker := new(Worker)
+	worker.ID = workerIDSequence
+	worker.Service = service
+	worker.State = service.Enabled
+	worker.Name = service.Name
+	worker.Tags = service.Tags
+	worker.DependsOn = service.DependsOn
+	worker.WarnLatency = service.WarnLatency.Duration()
+	worker.CritLatency = service.CritLatency.Duration()
+	worker.StatusCodes = service.StatusCodes
+	worker.URL = service.Address
+	worker.Login = service.Login
+	worker.Password = service.Password
+	worker.Interval = service.CheckInterval.Duration()
+	if service.Schedule != "" {
+		worker.Schedule, _ = helper.ParseSchedule(service.Schedule)
+	}
+	worker.Priority = service.Priority
+	worker.Jitter = service.Jitter.Duration()
+	worker.index = -1
+	if worker.State {
+		worker.WorkerState = WorkerStateOK
 	}
+	worker.Req, _ = http.NewRequest("GET", worker.URL, nil)
+	worker.Req.SetBasicAuth(worker.Login, worker.Password.Value())
+	return worker
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -244,89 +414,188 @@ func (workManager *workManager) InitWorkers(cfg *helper.Config) {
 //----------------------------------------------------------------------------------------------------------------------
 func (workManager *workManager) CloseWorkers() {
 	for i := 0; i < len(workManager.Workers); i++ {
-		log.Debugf("workingLoop, закрытие рабочего потока с номером %d", workManager.Workers[i].ID)
-		if workManager.Workers[i].State {
-			workManager.Workers[i].CommandChan <- true
-			log.Debug("workingLoop, закрытие рабочих потоков, послана команда в поток")
-			<-workManager.Workers[i].CommandChan
-			log.Debug("workingLoop, закрытие рабочих потоков, получена команда из потока")
-			close(workManager.Workers[i].CommandChan)
-			workManager.Workers[i].State = false
-		}
+		workManager.stopWorker(workManager.Workers[i])
 	}
 }
 
 //----------------------------------------------------------------------------------------------------------------------
-// Проверка работоспособности указанного web-сервиса и отправка результата в data collector
+// Постановка рабочего потока в расписание. Отключённые сервисы не проверяются.
 //----------------------------------------------------------------------------------------------------------------------
-func (workManager *workManager) CheckWebService(worker *Worker, outerChan chan WorkerID, dataCollectorURL string) {
-
-	if worker == nil {
-		log.Debug("worker == nil")
+func (workManager *workManager) startWorker(worker *Worker) {
+	if !worker.State {
+		log.Debugf("workingLoop, сервис %s отключён", worker.Name)
 		return
 	}
+	if worker.Schedule != nil {
+		log.Debugf("workingLoop, запуск рабочего потока с номером %d, расписание %s", worker.ID, worker.Schedule.Spec)
+	} else {
+		log.Debugf("workingLoop, запуск рабочего потока с номером %d, интервал %v", worker.ID, worker.Interval)
+	}
+	workManager.scheduler.add(worker)
+}
 
-	log.Debugf("checkWebService [%d], запущен рабочий поток, интервал %d секунд", worker.ID, int(worker.Interval.Seconds()))
+//----------------------------------------------------------------------------------------------------------------------
+// Остановка рабочего потока с ожиданием завершения выполняемой проверки
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) stopWorker(worker *Worker) {
+	log.Debugf("workingLoop, закрытие рабочего потока с номером %d", worker.ID)
+	if worker.State {
+		workManager.scheduler.remove(worker)
+		workManager.mutex.Lock()
+		worker.State = false
+		workManager.mutex.Unlock()
+	}
+}
 
-	wait := worker.Interval
+//----------------------------------------------------------------------------------------------------------------------
+// Проверка работоспособности указанного web-сервиса и отправка результата в data collector.
+// Выполняется планировщиком на одном из потоков проверки. Результат отменённой проверки не учитывается.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) CheckWebService(ctx context.Context, worker *Worker) {
+	// Контроль необходимости закрытия
+	if atomic.LoadInt32(&workManager.Shutdown) == 1 {
+		log.Debugf("checkWebService [%d], workManager.Shutdown == 1", worker.ID)
+		return
+	}
 
-	// Рабочий цикл
-	for {
-		log.Debugf("checkWebService [%d], ожидание %.3f секунд", worker.ID, wait.Seconds())
+	// Рабочая проверка
+	checkResult := worker.check(ctx)
+	if ctx.Err() != nil {
+		log.Infof("checkWebService [%d], проверка сервиса %s прервана", worker.ID, worker.Name)
+		return
+	}
 
-		if !worker.State {
-			log.Infof("checkWebService [%d], выход из неактивного рабочего потока!", worker.ID)
-			return
-		}
+	// Контроль смены состояния сервиса и оповещение
+	workManager.processResult(worker, checkResult)
 
-		select {
-		case <-worker.CommandChan:
-			log.Infof("checkWebService [%d], выключение рабочего потока!", worker.ID)
-			worker.CommandChan <- true
-			return
+	// Отправить результат проверки сборщику данных
+	workManager.mutex.RLock()
+	dataCollectorURL := workManager.collectorURL
+	workManager.mutex.RUnlock()
+	response, err := makeRequest(ctx, "POST", dataCollectorURL, checkResult)
+	if err != nil {
+		log.Errorf("checkWebService [%d], Ошибка отправки данных в data collector: %v", worker.ID, err)
+	} else {
+		defer response.Body.Close()
+	}
+	log.Debugf("checkWebService [%d], Результат отправки данных в data collector: %+v", worker.ID, response)
 
-		case <-time.After(wait):
-			log.Debugf("checkWebService [%d], завершение ожидания", worker.ID)
-			if !worker.State {
-				log.Info("checkWebService [%d], выход из неактивного рабочего потока!")
-				return
-			}
-			break
-		}
+	// Контрольный сигнал: время последней выполненной проверки
+	workManager.mutex.Lock()
+	worker.LastStateTime = time.Now()
+	workManager.mutex.Unlock()
+}
 
-		// Контроль необходимости закрытия
-		if workManager.Shutdown == 1 {
-			log.Debugf("checkWebService [%d], workManager.Shutdown == 1", worker.ID)
-			return
-		}
+//----------------------------------------------------------------------------------------------------------------------
+// Контроль смены состояния сервиса по результату проверки.
+// Состояние определяется уровнем важности результата: OK - up, WARNING - warning, CRITICAL - down.
+// При смене состояния отправляется оповещение о новом состоянии и отбой по прежнему.
+// Отбой отправляется и при первой успешной проверке: после перезапуска программы
+// могли остаться активные оповещения, сохранённые до остановки.
+// В окне обслуживания результат отмечается состоянием maintenance и оповещения не отправляются.
+// Если недоступна зависимость сервиса, отказ отмечается состоянием unreachable и оповещение
+// не отправляется: оповещать нужно только о первопричине.
+//----------------------------------------------------------------------------------------------------------------------
+func (workManager *workManager) processResult(worker *Worker, checkResult *CheckResult) {
+	evaluate(worker, checkResult)
+	if checkResult.StatusCode == http.StatusUnauthorized {
+		// Пароль мог смениться во внешнем хранилище секретов
+		helper.RefreshSecrets()
+	}
+	state := ServiceStateUp
+	switch checkResult.Severity {
+	case SeverityWarning:
+		state = ServiceStateWarning
+	case SeverityCritical:
+		state = ServiceStateDown
+	}
+	if maintenance, reason := alert.InMaintenance(worker.Name, worker.Tags, time.Now()); maintenance {
+		log.Debugf("checkWebService [%d], сервис %s на обслуживании: %s", worker.ID, worker.Name, reason)
+		state = ServiceStateMaintenance
+	}
 
-		// Mark the starting time
-		startTime := time.Now()
+	workManager.mutex.Lock()
+	if state == ServiceStateDown {
+		if parent := workManager.failedDependency(worker); parent != "" {
+			log.Infof("checkWebService [%d], сервис %s недоступен из-за зависимости %s", worker.ID, worker.Name, parent)
+			state = ServiceStateUnreachable
+		}
+	}
+	checkResult.State = state
+	previous := worker.ServiceState
+	worker.ServiceState = state
+	if previous != state {
+		worker.StateSince = time.Now()
+	}
+	stateSince := worker.StateSince
+	workManager.mutex.Unlock()
+
+	// Учёт результата в метриках, истории SLO и базовой линии.
+	// Проверки в окне обслуживания в SLO не учитываются.
+	labels := map[string]string{"service": worker.Name}
+	up := 0.0
+	if checkResult.Severity != SeverityCritical {
+		up = 1
+	}
+	metrics.SetGauge("ws_check_up", "Результат последней проверки сервиса: 1 - доступен", labels, up)
+	metrics.SetGauge("ws_check_severity", "Уровень важности последней проверки: 0 - OK, 1 - WARNING, 2 - CRITICAL",
+		labels, float64(severityLevel(checkResult.Severity)))
+	metrics.SetGauge("ws_check_duration_seconds", "Длительность последней проверки сервиса", labels, checkResult.CheckDuration.Seconds())
+	metrics.AddCounter("ws_checks_total", "Количество проверок сервиса по состояниям",
+		map[string]string{"service": worker.Name, "state": state}, 1)
+	if worker.Service.PerBackend {
+		recordBackends(worker, checkResult)
+	}
+	if state != ServiceStateMaintenance {
+		slo.Record(worker.Name, worker.URL, worker.Tags, time.Now(), checkResult.Severity != SeverityCritical, checkResult.CheckDuration)
+	}
+	// Базовая линия времени ответа строится только по проверкам, на которые сервис ответил без ошибки.
+	// Проверки в окне обслуживания не учитываются: работы на сервисе искажают время ответа.
+	if state != ServiceStateMaintenance && checkResult.Error == "" && checkResult.Severity != SeverityCritical {
+		checkResult.Degraded = baseline.Observe(worker.Name, worker.URL, worker.Tags, time.Now(), checkResult.CheckDuration)
+		workManager.mutex.Lock()
+		worker.degraded = checkResult.Degraded
+		workManager.mutex.Unlock()
+	}
 
-		// Рабочая проверка
-		//checkResult := check(worker.URL, worker.Login, worker.Password)
-		checkResult := check(worker.URL, worker.Req)
+	if previous == state {
+		return
+	}
+	log.Infof("checkWebService [%d], состояние сервиса %s: %s -> %s", worker.ID, worker.Name, previous, state)
+	// Оповещение сервиса с недоступной зависимостью остаётся активным и подавляется диспетчером,
+	// отбой по нему отправляется, только если получатель был уведомлён о срабатывании
+	if state == ServiceStateMaintenance || state == ServiceStateUnreachable {
+		return
+	}
 
-		// Отправить результат проверки сборщику данных
-		response, err := makeRequest("POST", dataCollectorURL, checkResult)
-		if err != nil {
-			log.Errorf("checkWebService [%d], Ошибка отправки данных в data collector: %v", worker.ID, err)
+	firing := ""
+	switch state {
+	case ServiceStateDown:
+		firing = alert.AlertServiceDown
+	case ServiceStateWarning:
+		firing = alert.AlertServiceWarning
+	}
+	for _, name := range []string{alert.AlertServiceDown, alert.AlertServiceWarning} {
+		a := &alert.Alert{
+			Name:     name,
+			Service:  worker.Name,
+			Address:  worker.URL,
+			Severity: alert.SeverityCritical,
+			Tags:     worker.Tags,
+			StartsAt: stateSince,
+		}
+		if name == alert.AlertServiceWarning {
+			a.Severity = alert.SeverityWarning
+		}
+		if name == firing {
+			a.Status = alert.StatusFiring
+			a.Summary = checkResult.Reason
 		} else {
-			defer response.Body.Close()
+			a.Status = alert.StatusResolved
+			a.EndsAt = time.Now()
+			a.Summary = "Состояние сервиса: " + state
 		}
-		log.Debugf("checkWebService [%d], Результат отправки данных в data collector: %+v", worker.ID, response)
-
-		// Отправить контрольный сигнал
-		outerChan <- worker.ID
-
-		// Mark the ending time
-		endTime := time.Now()
-
-		// Calculate the amount of time to wait to start workManager again.
-		duration := endTime.Sub(startTime)
-		log.Debugf("checkWebService [%d], Длительность выполнения рабочей проверки: %.3f секунд", worker.ID, duration.Seconds())
-		wait = worker.Interval - duration
-		log.Debugf("checkWebService [%d], Следующее ожидание: %.3f секунд", worker.ID, wait.Seconds())
+		alert.Notify(a)
 	}
 }
 
@@ -334,8 +603,8 @@ func (workManager *workManager) CheckWebService(worker *Worker, outerChan chan W
 // Проверка подключения к web-сервису
 // возвращает true — если сервис доступен, false, если нет и текст сообщения
 //----------------------------------------------------------------------------------------------------------------------
-//func check(url string, login string, password string) *CheckResult {
-func check(url string, req *http.Request) *CheckResult {
+// func check(url string, login string, password string) *CheckResult {
+func check(url string, req *http.Request, client *http.Client) *CheckResult {
 	// Подготовка результата работы функции проверки
 	var checkResult *CheckResult = new(CheckResult)
 
@@ -352,7 +621,6 @@ func check(url string, req *http.Request) *CheckResult {
 	// Попытка подключения
 	//req, _ := http.NewRequest("GET", url, nil)
 	//req.SetBasicAuth(login, password)
-	client := &http.Client{}
 	resp, err := client.Do(req)
 
 	//// do something with conn and put it back to the pool by closing the connection